@@ -24,7 +24,39 @@ func showHelp() {
 	gitlbry me [<channel_url>]
 
 	// View or change permissions.
-	gitlbry author <lbry_url> [[^]<channel_url>]*`);
+	gitlbry author <lbry_url> [[^]<channel_url>[=<role>|=<start>-[<end>]]]*
+	gitlbry author <lbry_url> [^]<channel_url>+ [--from <date>] [--until <date>]
+	gitlbry author <lbry_url> --sync <authors_file> [--dry-run]
+
+	// View or change which refs patches may change
+	gitlbry protect <lbry_url> [[^]<ref_pattern>[=<option>[,<option>]*]]*
+
+	// Rewrite the settings in the latest format
+	gitlbry upgrade <lbry_url>
+
+	// Ignore or stop ignoring patches
+	gitlbry delete <lbry_url> <patch>+
+	gitlbry undelete <lbry_url> <patch>+
+
+	// Show the history of who could push and what they pushed
+	gitlbry audit <lbry_url> [--json]
+
+	// View or change the description of a repo
+	gitlbry info <lbry_url> [--set <key>=<value>]*
+
+	// Hand a repo to another channel or wallet
+	gitlbry transfer <lbry_url> <channel_url>|<address>
+
+	// Stop or resume accepting patches
+	gitlbry freeze <lbry_url>
+	gitlbry unfreeze <lbry_url>
+
+	// View or change who can recover the repo if the owner is compromised
+	gitlbry recovery <lbry_url> [[^]<channel_url>]*
+	gitlbry recover <lbry_url> <channel_url> <settings_file>
+
+	// Approve a patch to refs that require approvals
+	gitlbry approve <lbry_url> [<patch>]`);
 }
 
 func showInitHelp() {
@@ -35,7 +67,8 @@ gitlbry init <lbry_url>
 	url.
 	
 	<lbry_url> The lbry url for the repo. You may omit the "lbry://" prefix for
-	           convieniance. 
+	           convieniance.  Links copied from web frontends such as
+	           https://odysee.com/... are also accepted.
 
 `);
 }
@@ -54,23 +87,257 @@ gitlbry me [<channel_url>]
 
 func showAuthorHelp() {
 		log.Fatal(`useage:	
-gitlbry author <lbry_url> [[^]<channel_url>]*
+gitlbry author <lbry_url> [[^]<channel_url>[=<role>|=<start>-[<end>]]]*
+gitlbry author <lbry_url> [^]<channel_url>+ [--from <date>] [--until <date>]
+gitlbry author <lbry_url> --sync <authors_file> [--dry-run]
 
   With zero <channel_url>, prints a list all channels that have ever had push
-  permision along with their current permission.
+  permision along with their current permission and upcoming changes.
 
   With one or more <channel_url> grants and revokes push privilidges for each 
   channel.   Channels prefixed with ^ will privlidge revoked, others will have 
   privledge granted.  Error if any channel cannot be resolved on the lbry 
 	network 
 
+  A channel followed by =<start>-<end> is granted or revoked push privilidge
+  for patches <start> through <end> inclusive, regardless of when they are
+  published.  Omit <end> for no limit e.g. @alice=0-499 or ^@bob=500-
+  Revoking a channel without a range also revokes its patch ranges from the
+  first patch nobody has published yet.
+
+  With --from and/or --until the channels are granted or revoked push
+  privilidge for patches published between the two dates, which may be in
+  the future.  --from defaults to now and --until to no end.  Dates are
+  YYYY-MM-DD in local time or RFC3339 times, a date given to --until
+  includes that day e.g. @carol --from 2024-07-01 --until 2024-07-31
+  Dates are stored as the block heights expected at those times, so windows
+  may start or end a little before or after the date.  Revoking a channel
+  without --from or --until also revokes its scheduled windows.
+
+  A channel followed by =maintainer is made a maintainer, ^ makes it a plain
+  pusher again.  Maintainers may grant and revoke push privilidge for other
+  channels, but only the owner of the repo may change roles or patch ranges.
+
+  With --sync, makes the authors match <authors_file> in a single update and
+  prints the changes.  Channels missing from the file lose push privilidge
+  and their role.  With --dry-run the changes are only printed.
+
   <lbry_url>    A lbry url to the repository.  For convieniance, the prefix 
-                "lbry://" may be omitted.
+                "lbry://" may be omitted.  Links copied from web frontends
+                such as https://odysee.com/... are also accepted.
 
   <channel_url> The lbry url for the channel.  For convieniance, the prefix
 	              "lbry://" or "lbry://@" may be omitted.  
+
+  <authors_file> A json file listing every channel that should have access
+                 e.g. {"authors": [
+                        {"channel": "@alice", "role": "maintainer"},
+                        {"channel": "@bob", "patches": ["0-499"]}]}
+                 role is pusher if omitted.  Channels with patches may only
+                 publish those patches.
+`)}
+
+func showProtectHelp() {
+		log.Fatal(`useage:	
+gitlbry protect <lbry_url> [[^]<ref_pattern>[=<option>[,<option>]*]]*
+
+  With zero <ref_pattern>, prints the rules for which refs patches may change.
+
+  With one or more <ref_pattern> adds or replaces the rule for each pattern.
+  Patterns prefixed with ^ have their rule removed.  Only the owner of the
+  repo may change the rules.  Patches that break a rule are ignored when
+  syncing, and git push refuses them.
+
+  <ref_pattern> A full ref name where * matches anything but / e.g.
+                refs/heads/main, refs/tags/* or refs/heads/release/*
+
+  <option>      maintainers    only maintainers may update the ref
+                immutable      the ref may be created but never moved
+                fast-forward   the ref may only be fast-forwarded
+                approvals=<k>  patches changing the ref wait for <k>
+                               maintainers to gitlbry approve them
+                <channel_url>  the channel may update the ref, and if any
+                               channel is listed only those channels and
+                               maintainers (if given) may
+
+  e.g. gitlbry protect repo refs/heads/main=maintainers,fast-forward refs/tags/*=immutable
+`)}
+
+func showUpgradeHelp() {
+		log.Fatal(`useage:	
+gitlbry upgrade <lbry_url>
+
+  Rewrites the repo's settings in the latest format.  Older versions of
+  gitlbry misread a repo once it is upgraded, so only upgrade once everyone
+  who uses the repo has updated gitlbry.  Settings in the old format can't be
+  changed until they are upgraded.  Only the owner of the repo may upgrade it.
+
+  <lbry_url>    A lbry url to the repository.  For convieniance, the prefix 
+                "lbry://" may be omitted.
+`)}
+
+func showDeleteHelp() {
+		log.Fatal(`useage:	
+gitlbry delete <lbry_url> <patch>+
+gitlbry undelete <lbry_url> <patch>+
+
+  Delete adds patches to the repo's deleted list so that they are ignored, as
+  if they were never published, e.g. to remove vandalism or oversized
+  patches.  Undelete removes them from the list.  Clones that already applied
+  a deleted patch roll back to before it on their next fetch.  Only the owner
+  of the repo may delete patches.
+
+  <lbry_url>    A lbry url to the repository.  For convieniance, the prefix 
+                "lbry://" may be omitted.
+
+  <patch>       The claim id of a patch, or its index when run in a clone of
+                the repo that has fetched it.
+`)}
+
+func showAuditHelp() {
+		log.Fatal(`useage:	
+gitlbry audit <lbry_url> [--json]
+
+  Prints the history of the repo's permissions: each update of the settings,
+  every grant and revoke with the block height or time it took effect and
+  who made it, and the patches each channel published in each window of
+  push access.  Grants and revokes made by maintainers are attributed to
+  their channel, others to the owner.
+
+  Earlier versions of the settings are read from the copies a clone keeps
+  as it fetches, or else fetched from the lbry network by the hash in each
+  version's claim, which works outside a clone as long as peers still have
+  the version.  Versions that can't be read either way are marked
+  unavailable.
+
+  --json        Print the history as json
+
+  <lbry_url>    A lbry url to the repository.  For convieniance, the prefix 
+                "lbry://" may be omitted.
+`)}
+
+func showInfoHelp() {
+		log.Fatal(`useage:	
+gitlbry info <lbry_url> [--set <key>=<value>]*
+
+  With no --set, prints the description of the repo.  Doesn't need a clone
+  of the repo.
+
+  With one or more --set, changes the description.  An empty <value> clears
+  the key.  The repo's title, description and tags on the lbry network are
+  updated to match so the repo can be found.  Only the owner of the repo may
+  change the description.
+
+  <key>         description     a sentence or two about the repo
+                license         e.g. MIT
+                homepage        a url
+                default_branch  e.g. main
+                topics          comma separated, used as lbry tags
+                contact         channel url to reach the maintainers
+
+  e.g. gitlbry info repo --set "description=A tool" --set topics=go,git
+`)}
+
+func showTransferHelp() {
+		log.Fatal(`useage:	
+gitlbry transfer <lbry_url> <channel_url>|<address>
+
+  Hands the repo to another channel or wallet.  Only the owner of the repo
+  may transfer it.
+
+  If the channel is in this wallet, publishes the repo's settings again under
+  the channel, e.g. lbry://@channel/repo, and points the old url there.
+  Clones of the old url follow the move, keeping the authors, their history
+  and the patches.  Outputs the new url.
+
+  Otherwise sends the repo's settings claim to the wallet address, or the
+  wallet that holds the channel, which becomes the owner.  The url of the
+  repo doesn't change, unless the repo is published under a channel e.g.
+  lbry://@channel/repo.  The new owner can't sign with that channel, so the
+  signature is removed and the repo is then only found at its permanent url
+  e.g. lbry://repo#<claim_id>.
+
+  <lbry_url>    A lbry url to the repository.  For convieniance, the prefix 
+                "lbry://" may be omitted.
+
+  <channel_url> The lbry url for the channel.  For convieniance, the prefix
+	              "lbry://" or "lbry://@" may be omitted.
+
+  <address>     A lbry wallet address e.g. from lbrynet address unused
+`)}
+
+func showFreezeHelp() {
+		log.Fatal(`useage:	
+gitlbry freeze <lbry_url>
+gitlbry unfreeze <lbry_url>
+
+  Freeze stops the repo accepting patches, e.g. after a channel's keys leak.
+  Patches published from the block where the freeze confirms are ignored,
+  and git push refuses to publish them.  Patches published before the freeze
+  can be removed with gitlbry delete.  Unfreeze accepts patches again from
+  the block where it confirms, patches published while frozen stay ignored.
+  Only the owner of the repo may freeze it.  Freezing doesn't wait for an
+  earlier change to the settings to confirm.
+
+  <lbry_url>    A lbry url to the repository.  For convieniance, the prefix 
+                "lbry://" may be omitted.
 `)}
 
+func showRecoveryHelp() {
+		log.Fatal(`useage:	
+gitlbry recovery <lbry_url> [[^]<channel_url>]*
+gitlbry recover <lbry_url> <channel_url> <settings_file>
+
+  Recovery channels can replace the settings of a repo if the owner's channel
+  or wallet is compromised.  Keep their keys in a different wallet, ideally
+  offline.
+
+  With one arguement, recovery prints the recovery channels.  Otherwise adds
+  each channel as a recovery channel, or removes it if prefixed with ^.  Only
+  the owner of the repo may change them.  Clones pin the recovery channels
+  they first see, later changes only reach existing clones through recover.
+
+  recover publishes <settings_file> as the repo's new settings, signed by the
+  recovery channel <channel_url>.  Use a copy of the settings from before the
+  compromise e.g. .glbry/<hash>/settings.json of a clone that hasn't fetched
+  since.  Clones that pinned the channel use the new settings from then on.
+  To hand the recovered repo to a new owner, use gitlbry transfer with the
+  url printed by recover.
+
+  <lbry_url>       A lbry url to the repository.  For convieniance, the prefix 
+                   "lbry://" may be omitted.
+
+  <channel_url>    The lbry url for the channel.  For convieniance, the prefix
+                   "lbry://" or "lbry://@" may be omitted.
+
+  <settings_file>  A settings.json naming <channel_url> as a recovery channel
+`)}
+
+func showApproveHelp() {
+		log.Fatal(`useage:	
+gitlbry approve <lbry_url> [<patch>]
+
+  With one arguement, prints the patch waiting for approvals, if any.
+
+  Otherwise approves the patch as the channel pushes to <lbry_url> are made
+  as, the remote's channel=<channel_url> or else the channel set with
+  gitlbry me, which must be a maintainer.  Patches changing refs protected
+  with approvals=<count> aren't applied until that many maintainers other
+  than the author approve them.  Until then fetches stop at the patch and
+  pushes are refused.  Fetch first, the pending patch can be reviewed at
+  .glbry/<hash>/in/<patch>.bundle.  To reject it instead, the owner can
+  gitlbry delete it using the claim id in its url.
+
+  Any channel with push access can hold up the repo this way, since the
+  oldest patch is the one that counts.  Approvals only count if they
+  confirm within 4032 blocks, about a week, of the patch.  After that a
+  patch without enough approvals is skipped like a deleted one.
+
+  <lbry_url>    A lbry url to the repository, as given to git remote add.
+                For convieniance, the prefix "lbry://" may be omitted.
+
+  <patch>       The index of the pending patch
+`)}
 
 func main() {
 	
@@ -101,13 +368,94 @@ func main() {
 			showMeHelp();
 		}
 	case "author":
-		if len(args) == 1 {
+		if len(args) >= 3 && args[1] == "--sync" {
+			dryRun := len(args) == 4 && args[3] == "--dry-run";
+			if len(args) == 4 && !dryRun || len(args) > 4 {
+				showAuthorHelp();
+			}
+			handleErr(glib.CliAuthorSync(args[0], args[2], dryRun));
+		} else if len(args) == 1 {
 			handleErr(glib.CliAuthorList(args[0]));
 		} else if len(args) > 1 {
 			handleErr(glib.CliAuthorModify(args[0], args[1:]));
 		} else {
 			showAuthorHelp();
 		}
+	case "protect":
+		if len(args) == 1 {
+			handleErr(glib.CliProtectList(args[0]));
+		} else if len(args) > 1 {
+			handleErr(glib.CliProtectModify(args[0], args[1:]));
+		} else {
+			showProtectHelp();
+		}
+	case "upgrade":
+		if len(args) == 1 {
+			handleErr(glib.CliUpgrade(args[0]));
+		} else {
+			showUpgradeHelp();
+		}
+	case "delete", "undelete":
+		if len(args) > 1 {
+			handleErr(glib.CliDelete(args[0], args[1:], command == "undelete"));
+		} else {
+			showDeleteHelp();
+		}
+	case "audit":
+		if len(args) == 1 {
+			handleErr(glib.CliAudit(args[0], false));
+		} else if len(args) == 2 && args[1] == "--json" {
+			handleErr(glib.CliAudit(args[0], true));
+		} else {
+			showAuditHelp();
+		}
+	case "info":
+		if len(args) == 1 {
+			handleErr(glib.CliInfo(args[0]));
+			return;
+		}
+		var pairs []string;
+		for i := 1; i + 1 < len(args) && args[i] == "--set"; i += 2 {
+			pairs = append(pairs, args[i + 1]);
+		}
+		if len(args) < 3 || len(pairs) * 2 != len(args) - 1 {
+			showInfoHelp();
+		}
+		handleErr(glib.CliInfoSet(args[0], pairs));
+	case "transfer":
+		if len(args) == 2 {
+			handleErr(glib.CliTransfer(args[0], args[1]));
+		} else {
+			showTransferHelp();
+		}
+	case "freeze", "unfreeze":
+		if len(args) == 1 {
+			handleErr(glib.CliFreeze(args[0], command == "freeze"));
+		} else {
+			showFreezeHelp();
+		}
+	case "recovery":
+		if len(args) == 1 {
+			handleErr(glib.CliRecoveryList(args[0]));
+		} else if len(args) > 1 {
+			handleErr(glib.CliRecoveryModify(args[0], args[1:]));
+		} else {
+			showRecoveryHelp();
+		}
+	case "recover":
+		if len(args) == 3 {
+			handleErr(glib.CliRecover(args[0], args[1], args[2]));
+		} else {
+			showRecoveryHelp();
+		}
+	case "approve":
+		if len(args) == 1 {
+			handleErr(glib.CliApproveList(args[0]));
+		} else if len(args) == 2 {
+			handleErr(glib.CliApprove(args[0], args[1]));
+		} else {
+			showApproveHelp();
+		}
 	default:
 		showHelp();
 	}
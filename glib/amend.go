@@ -0,0 +1,395 @@
+package glib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Roles an author can have in glSettings.  Two more are implicit: the owner is
+// whoever's wallet holds the settings claim and may change anything, and
+// everyone else is a reader.
+const (
+	// May publish patches while their heights, times or ranges allow.  The
+	// default
+	rolePusher = "pusher"
+
+	// May also grant and revoke push access for pushers by publishing
+	// amendments.  Only the owner can make or unmake maintainers
+	roleMaintainer = "maintainer"
+)
+
+func isRole(x string) bool {
+	return x == rolePusher || x == roleMaintainer
+}
+
+func (a *glAuthor) role() string {
+	if a.Role == "" {
+		return rolePusher
+	}
+	return a.Role
+}
+
+// Sets the role of the channel, adding it as an author without push access
+// if necessary
+func (s *glSettings) setRole(name string, channelId string, role string) {
+	author := s.author(channelId)
+	if author == nil {
+		author = &glAuthor{
+			ClaimId:     channelId,
+			ChannelName: name,
+		}
+		s.Authors = append(s.Authors, author)
+	}
+
+	// Record when the role changes so that amendments and approvals made
+	// while the channel was a maintainer stay valid after it is demoted
+	wasMaintainer := author.role() == roleMaintainer
+	if wasMaintainer != (role == roleMaintainer) {
+		if wasMaintainer && len(author.MaintainerHeights) == 0 {
+			author.MaintainerHeights = []int64{0}
+		}
+		author.MaintainerHeights = append(author.MaintainerHeights, pendingHeight)
+	}
+
+	if role == rolePusher {
+		role = ""
+	}
+	author.Role = role
+}
+
+// Claim ids of the current maintainers
+func (s *glSettings) maintainerIds() []string {
+	var result []string
+	for _, author := range s.Authors {
+		if author.role() == roleMaintainer {
+			result = append(result, author.ClaimId)
+		}
+	}
+	return result
+}
+
+// Claim ids of every channel that has ever been a maintainer
+func (s *glSettings) pastMaintainerIds() []string {
+	var result []string
+	for _, author := range s.Authors {
+		if author.role() == roleMaintainer || len(author.MaintainerHeights) > 0 {
+			result = append(result, author.ClaimId)
+		}
+	}
+	return result
+}
+
+func (s *glSettings) isMaintainer(channelId string) bool {
+	author := s.author(channelId)
+	return author != nil && author.role() == roleMaintainer
+}
+
+// True if the channel was a maintainer at the given block height.  Channels
+// made maintainers before role changes were recorded have been maintainers
+// since the start
+func (s *glSettings) isMaintainerAt(channelId string, height int64) bool {
+	author := s.author(channelId)
+	if author == nil {
+		return false
+	}
+	if len(author.MaintainerHeights) == 0 {
+		return author.role() == roleMaintainer
+	}
+	return inWindows(author.MaintainerHeights, height)
+}
+
+// Only the owner can update the settings claim, so maintainers publish their
+// changes as amendments: small claims signed by their channel that are
+// merged into the settings at sync time.  Amendments take effect at the height
+// where they confirm.
+type glAmendment struct {
+
+	// Version of the amendment format, always 1
+	Gitlbry int `json:"gitlbry_amendment"`
+
+	// Claim id of the settings being amended
+	Settings string `json:"settings"`
+
+	Grant  []*glAmendedAuthor `json:"grant,omitempty"`
+	Revoke []*glAmendedAuthor `json:"revoke,omitempty"`
+}
+
+type glAmendedAuthor struct {
+	ClaimId     string `json:"claim_id"`
+	ChannelName string `json:"channel_name"`
+}
+
+// The lbry name amendments for the repo are published under
+func amendName(settingsClaimId string) string {
+	return fmt.Sprintf("gitlbry-%v-amend", settingsClaimId)
+}
+
+// A grant or revoke at a block height
+type heightEvent struct {
+	height int64
+	grant  bool
+
+	// True if the event comes from the settings rather than an amendment
+	owner bool
+}
+
+// Returns the boundaries after merging the events into them.  Events are
+// applied in height order, at equal heights the boundaries win since they
+// come from the owner.
+func mergeHeightEvents(boundaries []int64, events []heightEvent) []int64 {
+
+	var all []heightEvent
+	for i, h := range boundaries {
+		all = append(all, heightEvent{height: h, grant: i%2 == 0, owner: true})
+	}
+	all = append(all, events...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].height < all[j].height })
+
+	var result []int64
+	granted := false
+	ownerHeight := int64(-1)
+	for _, e := range all {
+		if e.owner {
+			ownerHeight = e.height
+		} else if e.height == ownerHeight {
+			continue
+		}
+		if e.grant != granted {
+			result = append(result, e.height)
+			granted = e.grant
+		}
+	}
+	return result
+}
+
+// Merges amendments into the settings.  Grants and revokes of channels that
+// were maintainers at the time are ignored, only the owner can change them.
+func (s *glSettings) applyAmendments(amendments []*amendmentClaim) {
+
+	events := map[string][]heightEvent{}
+	add := func(x *glAmendedAuthor, height int64, grant bool) {
+		author := s.author(x.ClaimId)
+		if author == nil {
+			author = &glAuthor{
+				ClaimId:     x.ClaimId,
+				ChannelName: x.ChannelName,
+			}
+			s.Authors = append(s.Authors, author)
+		}
+		if s.isMaintainerAt(x.ClaimId, height) {
+			return
+		}
+		events[x.ClaimId] = append(events[x.ClaimId], heightEvent{height: height, grant: grant})
+	}
+
+	for _, a := range amendments {
+		for _, x := range a.amendment.Grant {
+			add(x, int64(a.height), true)
+		}
+		for _, x := range a.amendment.Revoke {
+			add(x, int64(a.height), false)
+		}
+	}
+
+	for claimId, e := range events {
+		author := s.author(claimId)
+		author.Heights = mergeHeightEvents(author.Heights, e)
+	}
+}
+
+// An amendment found on the lbry network
+type amendmentClaim struct {
+	outpoint  string
+	height    int
+	amendment *glAmendment
+
+	// Claim id of the maintainer channel that signed it
+	channelId string
+}
+
+// Finds, downloads and validates the amendments to the settings, oldest
+// first.  Downloaded amendments are remembered in the cache if one is given.
+func findAmendments(settingsClaimId string, settings *glSettings, cache *claimCache) ([]*amendmentClaim, error) {
+
+	// Demoted maintainers' amendments still count up to their demotion
+	maintainers := settings.pastMaintainerIds()
+	if len(maintainers) == 0 {
+		return nil, nil
+	}
+
+	type arg struct {
+		Name                  string   `json:"name"`
+		ChannelIds            []string `json:"channel_ids"`
+		ValidChannelSignature bool     `json:"valid_channel_signature"`
+		PageSize              int      `json:"page_size"`
+		OrderBy               []string `json:"order_by"`
+	}
+
+	type signChan struct {
+		ClaimId string `json:"claim_id"`
+	}
+
+	type out struct {
+		withError
+		PermanentUrl            string    `json:"permanent_url"`
+		Txid                    string    `json:"txid"`
+		Nout                    int       `json:"nout"`
+		Height                  int       `json:"height"`
+		SigningChannel          *signChan `json:"signing_channel"`
+		IsChannelSignatureValid *bool     `json:"is_channel_signature_valid"`
+	}
+
+	page, err := rpcCall[arg, sdkPage[*out]]("claim_search", arg{
+		Name:                  amendName(settingsClaimId),
+		ChannelIds:            maintainers,
+		ValidChannelSignature: true,
+		PageSize:              5000,
+		OrderBy:               []string{"^height"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*amendmentClaim
+	for _, item := range page.Items {
+
+		if item.Error != nil ||
+			item.SigningChannel == nil ||
+			item.IsChannelSignatureValid == nil ||
+			!*item.IsChannelSignatureValid ||
+			item.Height <= 0 ||
+			!settings.isMaintainerAt(item.SigningChannel.ClaimId, int64(item.Height)) {
+			continue
+		}
+
+		outpoint := fmt.Sprintf("%v:%v", item.Txid, item.Nout)
+		a, err := loadAmendment(item.PermanentUrl, outpoint, cache)
+		if err != nil {
+			OutPrintf("ignoring unreadable amendment %v: %v", item.PermanentUrl, err)
+			continue
+		}
+
+		if a.Settings != settingsClaimId {
+			OutPrintf("ignoring amendment %v for other settings %v", item.PermanentUrl, a.Settings)
+			continue
+		}
+
+		result = append(result, &amendmentClaim{
+			outpoint:  outpoint,
+			height:    item.Height,
+			amendment: a,
+			channelId: item.SigningChannel.ClaimId,
+		})
+	}
+
+	return result, nil
+}
+
+func loadAmendment(url string, outpoint string, cache *claimCache) (*glAmendment, error) {
+
+	if cache != nil {
+		if a, ok := cache.Amendments[outpoint]; ok {
+			return a, nil
+		}
+	}
+
+	path, err := newTempPath()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	err = lbryGet(url, path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var a glAmendment
+	err = json.Unmarshal(b, &a)
+	if err != nil {
+		return nil, err
+	}
+	if a.Gitlbry != 1 {
+		return nil, errors.Errorf("unsupported amendment version %v", a.Gitlbry)
+	}
+
+	if cache != nil {
+		if cache.Amendments == nil {
+			cache.Amendments = map[string]*glAmendment{}
+		}
+		cache.Amendments[outpoint] = &a
+	}
+	return &a, nil
+}
+
+// Finds amendments to the settings and merges them in.  The amendments
+// found are kept in the cache if there is one, see foundAmendments
+func loadAmendments(settingsClaimId string, settings *glSettings, cache *claimCache) error {
+	amendments, err := findAmendments(settingsClaimId, settings, cache)
+	if err != nil {
+		return err
+	}
+	if cache != nil {
+		cache.Found = Map(amendments, func(a *amendmentClaim) *cachedAmendment {
+			return &cachedAmendment{Outpoint: a.outpoint, Height: a.height, ChannelId: a.channelId}
+		})
+	}
+	settings.applyAmendments(amendments)
+	return nil
+}
+
+// An amendment found by the last search, see claimCache.Found
+type cachedAmendment struct {
+	Outpoint  string `json:"outpoint"`
+	Height    int    `json:"height"`
+	ChannelId string `json:"channel_id"`
+}
+
+// Returns the amendments found by the last search.  False if the contents
+// of one of them are no longer cached
+func (c *claimCache) foundAmendments() ([]*amendmentClaim, bool) {
+	var result []*amendmentClaim
+	for _, f := range c.Found {
+		a, ok := c.Amendments[f.Outpoint]
+		if !ok {
+			return nil, false
+		}
+		result = append(result, &amendmentClaim{
+			outpoint:  f.Outpoint,
+			height:    f.Height,
+			amendment: a,
+			channelId: f.ChannelId,
+		})
+	}
+	return result, true
+}
+
+// Publishes an amendment signed by the given maintainer channel
+func publishAmendment(maintainerId string, a *glAmendment) error {
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	path, err := newTempPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path, b, 0666)
+	if err != nil {
+		return err
+	}
+
+	return lbryStreamCreateOnChannel(amendName(a.Settings), maintainerId, defaultBid, path)
+}
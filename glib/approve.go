@@ -0,0 +1,285 @@
+package glib
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Published by a maintainer to sign off on a patch that changes refs whose
+// rule requires approvals.  Names the exact bundle so that the approval can't
+// be reused for a different patch
+type glApproval struct {
+	Gitlbry int `json:"gitlbry_approval"`
+
+	// Claim id of the approved patch
+	Patch string `json:"patch"`
+
+	// sha1 hash of the approved patch's bundle file
+	Sha1 string `json:"sha1"`
+}
+
+// A patch that follows the rules but isn't applied until enough maintainers
+// approve it
+type PendingPatch struct {
+	ClaimId      string
+	PermanentUrl string
+	ChannelId    string
+	Sha1         string
+	Approvals    int
+	Required     int
+
+	// Approvals confirmed from this height don't count, see approvalDeadline
+	Deadline int64
+}
+
+// The lbry name approvals for a patch are published under
+func approveName(patchClaimId string) string {
+	return fmt.Sprintf("gitlbry-%v-approve", patchClaimId)
+}
+
+// Returns the most approvals required by a rule in effect at height matching
+// a ref the patch changes, zero if none are required
+func (s *glSettings) requiredApprovals(height int64, updates []refUpdate) int {
+	required := 0
+	for _, u := range updates {
+		if u.old == u.new {
+			continue
+		}
+		for _, rule := range s.Refs {
+			if rule.matches(u.name) && rule.inEffectAt(height) && rule.Approvals > required {
+				required = rule.Approvals
+			}
+		}
+	}
+	return required
+}
+
+// Blocks after a patch confirms within which its approvals have to confirm,
+// about a week at secondsPerBlock
+const approvalWindow = 4032
+
+// Approvals of the patch count if they confirm below this height.  Fixed by
+// the patch's own height so every clone counts the same approvals, whenever
+// it syncs.  math.MaxInt64 while the patch is unconfirmed
+func approvalDeadline(patch *bundleClaim) int64 {
+	if patch.Height <= 0 {
+		return math.MaxInt64
+	}
+	return int64(patch.Height) + approvalWindow
+}
+
+// True if an approval of the patch published by the channel and confirmed
+// at height counts.  It counts if it confirmed before the deadline and the
+// channel was a maintainer at that height.  The patch's own author doesn't
+// count
+func (s *glSettings) countsAsApproval(patch *bundleClaim, channelId string, height int64) bool {
+	return height > 0 &&
+		height < approvalDeadline(patch) &&
+		channelId != patch.ChannelId &&
+		s.isMaintainerAt(channelId, height)
+}
+
+// Finds the approvals of the patch that count, see countsAsApproval.
+// Returns the claim ids of the maintainers that approved it
+func findApprovals(patch *bundleClaim, sha1 string, settings *glSettings, cache *claimCache) ([]string, error) {
+
+	// Demoted maintainers' approvals still count up to their demotion
+	maintainers := settings.pastMaintainerIds()
+	if len(maintainers) == 0 {
+		return nil, nil
+	}
+
+	type arg struct {
+		Name                  string   `json:"name"`
+		ChannelIds            []string `json:"channel_ids"`
+		ValidChannelSignature bool     `json:"valid_channel_signature"`
+		PageSize              int      `json:"page_size"`
+		OrderBy               []string `json:"order_by"`
+	}
+
+	type signChan struct {
+		ClaimId string `json:"claim_id"`
+	}
+
+	type out struct {
+		withError
+		PermanentUrl            string    `json:"permanent_url"`
+		Txid                    string    `json:"txid"`
+		Nout                    int       `json:"nout"`
+		Height                  int       `json:"height"`
+		SigningChannel          *signChan `json:"signing_channel"`
+		IsChannelSignatureValid *bool     `json:"is_channel_signature_valid"`
+	}
+
+	page, err := rpcCall[arg, sdkPage[*out]]("claim_search", arg{
+		Name:                  approveName(patch.ClaimId),
+		ChannelIds:            maintainers,
+		ValidChannelSignature: true,
+		PageSize:              5000,
+		OrderBy:               []string{"^height"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	approved := map[string]bool{}
+	for _, item := range page.Items {
+
+		if item.Error != nil ||
+			item.SigningChannel == nil ||
+			item.IsChannelSignatureValid == nil ||
+			!*item.IsChannelSignatureValid ||
+			!settings.countsAsApproval(patch, item.SigningChannel.ClaimId, int64(item.Height)) ||
+			approved[item.SigningChannel.ClaimId] {
+			continue
+		}
+
+		outpoint := fmt.Sprintf("%v:%v", item.Txid, item.Nout)
+		a, err := loadApproval(item.PermanentUrl, outpoint, cache)
+		if err != nil {
+			OutPrintf("ignoring unreadable approval %v: %v", item.PermanentUrl, err)
+			continue
+		}
+
+		if a.Patch != patch.ClaimId || a.Sha1 != sha1 {
+			OutPrintf("ignoring approval %v for other patch %v %v", item.PermanentUrl, a.Patch, a.Sha1)
+			continue
+		}
+
+		approved[item.SigningChannel.ClaimId] = true
+		result = append(result, item.SigningChannel.ClaimId)
+	}
+
+	return result, nil
+}
+
+func loadApproval(url string, outpoint string, cache *claimCache) (*glApproval, error) {
+
+	if a, ok := cache.Approvals[outpoint]; ok {
+		return a, nil
+	}
+
+	path, err := newTempPath()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	err = lbryGet(url, path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var a glApproval
+	err = json.Unmarshal(b, &a)
+	if err != nil {
+		return nil, err
+	}
+	if a.Gitlbry != 1 {
+		return nil, errors.Errorf("unsupported approval version %v", a.Gitlbry)
+	}
+
+	if cache.Approvals == nil {
+		cache.Approvals = map[string]*glApproval{}
+	}
+	cache.Approvals[outpoint] = &a
+	return &a, nil
+}
+
+// Returns the patch as pending if it changes refs that require more
+// approvals than it has, nil if it can be applied.  Returns a
+// rejectedBundleErr once the approval window has passed without enough
+// approvals, so the next candidate is tried.  The patch's objects must
+// already be in the local clone
+//
+// The oldest authorized candidate for an index is canonical, so any pusher
+// can hold up the repo by publishing a patch that needs approvals.  The hold
+// lasts until maintainers approve it, the owner deletes it or the window
+// passes.
+func (rh RepoName) checkApprovals(bundle *bundleClaim, sha1 string, heads []NamedRef, settings *glSettings, cache *claimCache) (*PendingPatch, error) {
+
+	updates, err := rh.refUpdates(heads)
+	if err != nil {
+		return nil, err
+	}
+
+	required := settings.requiredApprovals(int64(bundle.Height), updates)
+	if required == 0 {
+		return nil, nil
+	}
+
+	approvals, err := findApprovals(bundle, sha1, settings, cache)
+	if err != nil {
+		return nil, err
+	}
+	if len(approvals) >= required {
+		OutPrintf("patch %v approved by %v", bundle.PermanentUrl, approvals)
+		return nil, nil
+	}
+
+	// Every approval that could count has confirmed
+	deadline := approvalDeadline(bundle)
+	tip, err := lbryBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+	if tip >= deadline {
+		return nil, &rejectedBundleErr{why: errors.Errorf("%v of %v approvals confirmed before block %v", len(approvals), required, deadline)}
+	}
+
+	return &PendingPatch{
+		ClaimId:      bundle.ClaimId,
+		PermanentUrl: bundle.PermanentUrl,
+		ChannelId:    bundle.ChannelId,
+		Sha1:         sha1,
+		Approvals:    len(approvals),
+		Required:     required,
+		Deadline:     deadline,
+	}, nil
+}
+
+// Describes who can clear a pending patch for display
+func (s *glSettings) pendingHelp(p *PendingPatch) string {
+	var approvers []string
+	for _, author := range s.Authors {
+		if author.role() == roleMaintainer && author.ClaimId != p.ChannelId {
+			approvers = append(approvers, fmt.Sprintf("%v:%v", author.ChannelName, author.ClaimId))
+		}
+	}
+	if len(approvers) == 0 {
+		return fmt.Sprintf("no maintainer can approve it, the owner can delete it with gitlbry delete <repo> %v", p.ClaimId)
+	}
+	return fmt.Sprintf("maintainers %v can approve it with gitlbry approve, or the owner can delete it with gitlbry delete <repo> %v", strings.Join(approvers, ", "), p.ClaimId)
+}
+
+// Publishes an approval of the patch signed by the given maintainer channel
+func publishApproval(maintainerId string, a *glApproval) error {
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	path, err := newTempPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path, b, 0666)
+	if err != nil {
+		return err
+	}
+
+	return lbryStreamCreateOnChannel(approveName(a.Patch), maintainerId, defaultBid, path)
+}
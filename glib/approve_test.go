@@ -0,0 +1,104 @@
+package glib
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRequiredApprovals(t *testing.T) {
+	rule, _, err := parseRefRule("refs/heads/release/*=approvals=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Approvals != 2 || rule.String() != "refs/heads/release/*=approvals=2" {
+		t.Errorf("parseRefRule() = %+v", rule)
+	}
+	for _, bad := range []string{"refs/heads/main=approvals", "refs/heads/main=approvals=0", "refs/heads/main=approvals=x"} {
+		if _, _, err := parseRefRule(bad); err == nil {
+			t.Errorf("parseRefRule(%q) should fail", bad)
+		}
+	}
+
+	s := &glSettings{Refs: []*glRefRule{rule, {Ref: "refs/heads/main", FastForward: true}}}
+	updates := []refUpdate{
+		{name: "refs/heads/main", old: "1", new: "2"},
+		{name: "refs/heads/release/v1", old: "3", new: "3"},
+	}
+	if got := s.requiredApprovals(100, updates); got != 0 {
+		t.Errorf("requiredApprovals() = %v, unchanged refs need no approvals", got)
+	}
+	updates[1].new = "4"
+	if got := s.requiredApprovals(100, updates); got != 2 {
+		t.Errorf("requiredApprovals() = %v", got)
+	}
+
+	// Patches confirmed before the rule took effect need no approvals
+	rule.Heights = []int64{50}
+	if got := s.requiredApprovals(40, updates); got != 0 {
+		t.Errorf("requiredApprovals() = %v before the rule took effect", got)
+	}
+
+	s.Authors = []*glAuthor{
+		{ClaimId: "aa", ChannelName: "@alice", Role: roleMaintainer},
+		{ClaimId: "bb", ChannelName: "@bob", Role: roleMaintainer},
+		{ClaimId: "cc", ChannelName: "@carol"},
+	}
+	want := "maintainers @bob:bb can approve it with gitlbry approve, or the owner can delete it with gitlbry delete <repo> pp"
+	if got := s.pendingHelp(&PendingPatch{ClaimId: "pp", ChannelId: "aa"}); got != want {
+		t.Errorf("pendingHelp() = %q", got)
+	}
+}
+
+func TestCountsAsApproval(t *testing.T) {
+	s := &glSettings{
+		Authors: []*glAuthor{
+			{ClaimId: "aa", Role: roleMaintainer},
+			{ClaimId: "bb", Role: roleMaintainer},
+			{ClaimId: "cc", MaintainerHeights: []int64{0, 120}},
+			{ClaimId: "dd", Role: roleMaintainer, MaintainerHeights: []int64{150}},
+			{ClaimId: "ee"},
+		},
+	}
+	patch := &bundleClaim{ClaimId: "pp", ChannelId: "aa", Height: 100}
+
+	tests := []struct {
+		channel string
+		height  int64
+		counts  bool
+	}{
+		{"bb", 110, true},
+		{"bb", 0, false},   // unconfirmed
+		{"aa", 110, false}, // the patch's author
+		{"cc", 110, true},  // demoted later
+		{"cc", 130, false}, // after the demotion
+		{"dd", 140, false}, // before the promotion
+		{"dd", 160, true},
+		{"ee", 110, false}, // never a maintainer
+	}
+
+	for _, tt := range tests {
+		if got := s.countsAsApproval(patch, tt.channel, tt.height); got != tt.counts {
+			t.Errorf("countsAsApproval(%v, %v) = %v", tt.channel, tt.height, got)
+		}
+	}
+}
+
+func TestApprovalDeadline(t *testing.T) {
+	if got := approvalDeadline(&bundleClaim{Height: 0}); got != math.MaxInt64 {
+		t.Errorf("approvalDeadline() = %v for an unconfirmed patch", got)
+	}
+
+	// Later patches built on the pending one don't move the deadline
+	patch := &bundleClaim{ClaimId: "pp", ChannelId: "aa", Height: 100}
+	if got := approvalDeadline(patch); got != 100+approvalWindow {
+		t.Errorf("approvalDeadline() = %v", got)
+	}
+
+	s := &glSettings{Authors: []*glAuthor{{ClaimId: "bb", Role: roleMaintainer}}}
+	if !s.countsAsApproval(patch, "bb", 100+approvalWindow-1) {
+		t.Errorf("approval confirmed inside the window didn't count")
+	}
+	if s.countsAsApproval(patch, "bb", 100+approvalWindow) {
+		t.Errorf("approval confirmed after the window counted")
+	}
+}
@@ -0,0 +1,382 @@
+package glib
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The history of a repo's permissions, rebuilt from the settings claim's
+// versions, the changes each made, the amendments and the patches on the lbry
+// network
+type auditLog struct {
+	Repo string `json:"repo"`
+
+	// Versions of the settings claim, oldest first
+	Updates []*auditUpdate `json:"updates"`
+
+	// Grants and revokes, height events first in height order then time
+	// events in time order
+	Events []*auditEvent `json:"events"`
+
+	Authors []*auditAuthor `json:"authors"`
+}
+
+type auditUpdate struct {
+	Txid   string `json:"txid"`
+	Height int    `json:"height"`
+
+	// "create" or "update"
+	Op string `json:"op"`
+
+	// Set if this version couldn't be read, so its changes are reported with
+	// the next version that could.  See settingsVersion
+	Missing bool `json:"missing,omitempty"`
+}
+
+type auditEvent struct {
+
+	// Block height or unix time the event took effect at, one is zero
+	Height int64 `json:"height,omitempty"`
+	Time   int64 `json:"time,omitempty"`
+
+	// "grant" or "revoke", or for other changes to the author "heights",
+	// "schedule", "patches", "role" or "remove"
+	Action string `json:"action"`
+
+	// The author's new heights, schedule, patch ranges or role
+	Detail string `json:"detail,omitempty"`
+
+	ChannelId   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+
+	// "owner" or the claim id of the maintainer that published the amendment
+	By string `json:"by"`
+
+	// Transaction of the settings update or amendment that made the change,
+	// empty if it isn't known
+	Txid string `json:"txid,omitempty"`
+}
+
+type auditAuthor struct {
+	ChannelId   string         `json:"channel_id"`
+	ChannelName string         `json:"channel_name"`
+	Role        string         `json:"role"`
+	Windows     []*auditWindow `json:"windows"`
+}
+
+// A span of push access and the patches the author published in it
+type auditWindow struct {
+
+	// "heights", "times" or "patches"
+	Kind string `json:"kind"`
+
+	// Start is inclusive, End exclusive and -1 for no end
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+
+	// Indices of the patches published in the window
+	Patches []int `json:"patches"`
+}
+
+// A patch claim found on the lbry network, canonical or not
+type auditPatch struct {
+	Index     int
+	ClaimId   string
+	ChannelId string
+	Height    int
+	Timestamp int64
+}
+
+// Events for the changes a version of the settings made to the version
+// before it, prior is nil for the first version known.  New height boundaries
+// take effect at their height, other changes at the height the version
+// confirmed at
+func versionEvents(prior *glSettings, next *glSettings, u *auditUpdate) []*auditEvent {
+
+	if prior == nil {
+		prior = &glSettings{}
+	}
+
+	at := int64(u.Height)
+	if at <= 0 {
+		at = math.MaxInt64
+	}
+
+	var events []*auditEvent
+	add := func(author *glAuthor, height int64, action string, detail string) {
+		events = append(events, &auditEvent{
+			Height:      height,
+			Action:      action,
+			Detail:      detail,
+			ChannelId:   author.ClaimId,
+			ChannelName: author.ChannelName,
+			By:          "owner",
+			Txid:        u.Txid,
+		})
+	}
+
+	for _, author := range next.Authors {
+
+		old := prior.author(author.ClaimId)
+		if old == nil {
+			old = &glAuthor{}
+		}
+
+		if isPrefix(old.Heights, author.Heights) {
+			for i := len(old.Heights); i < len(author.Heights); i += 1 {
+				action := "grant"
+				if i%2 == 1 {
+					action = "revoke"
+				}
+				add(author, author.Heights[i], action, "")
+			}
+		} else {
+			add(author, at, "heights", formatBoundaries("heights", author.Heights))
+		}
+
+		if !reflect.DeepEqual(old.Times, author.Times) {
+			add(author, at, "schedule", formatBoundaries("times", author.Times))
+		}
+
+		if !reflect.DeepEqual(old.Ranges, author.Ranges) {
+			add(author, at, "patches", strings.Join(Map(author.Ranges, glRange.String), ","))
+		}
+
+		if old.role() != author.role() {
+			add(author, at, "role", author.role())
+		}
+	}
+
+	for _, author := range prior.Authors {
+		if next.author(author.ClaimId) == nil {
+			add(author, at, "remove", "")
+		}
+	}
+
+	return events
+}
+
+func isPrefix(prefix []int64, x []int64) bool {
+	if len(prefix) > len(x) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != x[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Formats boundaries as windows e.g. "100-200,300-"
+func formatBoundaries(kind string, boundaries []int64) string {
+	windows := boundaryWindows(kind, boundaries)
+	return strings.Join(Map(windows, func(w *auditWindow) string {
+		return formatBound(kind, w.Start) + "-" + formatBound(kind, w.End)
+	}), ",")
+}
+
+func formatBound(kind string, x int64) string {
+	switch {
+	case x < 0 || x == math.MaxInt64:
+		return ""
+	case kind == "times":
+		return time.Unix(x, 0).UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func amendmentEvents(amendments []*amendmentClaim) []*auditEvent {
+	var events []*auditEvent
+	add := func(a *amendmentClaim, x *glAmendedAuthor, action string) {
+		txid, _, _ := strings.Cut(a.outpoint, ":")
+		events = append(events, &auditEvent{
+			Height:      int64(a.height),
+			Action:      action,
+			ChannelId:   x.ClaimId,
+			ChannelName: x.ChannelName,
+			By:          a.channelId,
+			Txid:        txid,
+		})
+	}
+	for _, a := range amendments {
+		for _, x := range a.amendment.Grant {
+			add(a, x, "grant")
+		}
+		for _, x := range a.amendment.Revoke {
+			add(a, x, "revoke")
+		}
+	}
+	return events
+}
+
+func sortEvents(events []*auditEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if (a.Time == 0) != (b.Time == 0) {
+			return a.Time == 0
+		}
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		return a.Height < b.Height
+	})
+}
+
+// Splits boundaries into windows, the last is open if there are an odd
+// number of boundaries
+func boundaryWindows(kind string, boundaries []int64) []*auditWindow {
+	var windows []*auditWindow
+	for i := 0; i < len(boundaries); i += 2 {
+		w := &auditWindow{Kind: kind, Start: boundaries[i], End: -1}
+		if i+1 < len(boundaries) {
+			w.End = boundaries[i+1]
+		}
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+// Returns the author's windows of push access with the patches they published
+// in each
+func auditWindows(author *glAuthor, patches []*auditPatch) []*auditWindow {
+
+	windows := boundaryWindows("heights", author.Heights)
+	windows = append(windows, boundaryWindows("times", author.Times)...)
+	for _, r := range author.Ranges {
+		windows = append(windows, &auditWindow{Kind: "patches", Start: r.Start, End: r.End})
+	}
+
+	for _, w := range windows {
+		end := w.End
+		if end < 0 {
+			end = math.MaxInt64
+		}
+		w.Patches = []int{}
+		for _, p := range patches {
+			var x int64
+			switch w.Kind {
+			case "heights":
+				x = int64(p.Height)
+			case "times":
+				x = p.Timestamp
+			default:
+				x = int64(p.Index)
+			}
+			if p.ChannelId == author.ClaimId && w.Start <= x && x < end {
+				w.Patches = append(w.Patches, p.Index)
+			}
+		}
+	}
+
+	return windows
+}
+
+// Finds the confirmed patch claims published by authors, stopping at the
+// first index with none
+func findAuditPatches(rh RepoName, settings *glSettings) ([]*auditPatch, error) {
+
+	type arg struct {
+		Name       string   `json:"name"`
+		ChannelIds []string `json:"channel_ids"`
+		PageSize   int      `json:"page_size"`
+		OrderBy    []string `json:"order_by"`
+	}
+
+	type signChan struct {
+		ClaimId string `json:"claim_id"`
+	}
+
+	type out struct {
+		withError
+		ClaimId        string    `json:"claim_id"`
+		Height         int       `json:"height"`
+		Timestamp      int64     `json:"timestamp"`
+		SigningChannel *signChan `json:"signing_channel"`
+	}
+
+	var result []*auditPatch
+	for index := 0; ; index += 1 {
+
+		page, err := rpcCall[arg, sdkPage[*out]]("claim_search", arg{
+			Name:       settings.patchName(rh, index),
+			ChannelIds: Map(settings.Authors, func(a *glAuthor) string { return a.ClaimId }),
+			PageSize:   5000,
+			OrderBy:    []string{"^height"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Items) == 0 {
+			return result, nil
+		}
+
+		for _, item := range page.Items {
+			if item.Error != nil || item.SigningChannel == nil || settings.isDeleted(item.ClaimId) {
+				continue
+			}
+			result = append(result, &auditPatch{
+				Index:     index,
+				ClaimId:   item.ClaimId,
+				ChannelId: item.SigningChannel.ClaimId,
+				Height:    item.Height,
+				Timestamp: item.Timestamp,
+			})
+		}
+	}
+}
+
+func (l *auditLog) print(asJson bool) error {
+
+	if asJson {
+		b, err := json.MarshalIndent(l, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	fmt.Printf("%v\n", l.Repo)
+
+	fmt.Printf("\nsettings updates\n")
+	for _, u := range l.Updates {
+		missing := ""
+		if u.Missing {
+			missing = " (unavailable)"
+		}
+		fmt.Printf("  height %v %v %v%v\n", u.Height, u.Op, u.Txid, missing)
+	}
+
+	fmt.Printf("\ngrants and revokes\n")
+	for _, e := range l.Events {
+		when := "height " + formatBound("heights", e.Height)
+		if e.Time != 0 {
+			when = formatBound("times", e.Time)
+		} else if e.Height == math.MaxInt64 {
+			when = "pending"
+		}
+		action := e.Action
+		if e.Detail != "" {
+			action += " " + e.Detail
+		}
+		fmt.Printf("  %v %v %v:%v by %v %v\n", when, action, e.ChannelName, e.ChannelId, e.By, e.Txid)
+	}
+
+	fmt.Printf("\npatches by author\n")
+	for _, a := range l.Authors {
+		fmt.Printf("  %v:%v %v\n", a.ChannelName, a.ChannelId, a.Role)
+		for _, w := range a.Windows {
+			patches := strings.Join(Map(w.Patches, func(i int) string { return fmt.Sprint(i) }), ",")
+			fmt.Printf("    %v %v-%v: %v\n", w.Kind, formatBound(w.Kind, w.Start), formatBound(w.Kind, w.End), patches)
+		}
+	}
+
+	return nil
+}
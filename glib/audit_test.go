@@ -0,0 +1,64 @@
+package glib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditWindows(t *testing.T) {
+	author := &glAuthor{
+		ClaimId: "aa",
+		Heights: []int64{10, 20, 30},
+		Times:   []int64{100, 200},
+		Ranges:  []glRange{{Start: 5, End: -1}},
+	}
+	patches := []*auditPatch{
+		{Index: 0, ChannelId: "aa", Height: 12, Timestamp: 50},
+		{Index: 1, ChannelId: "bb", Height: 15, Timestamp: 150},
+		{Index: 2, ChannelId: "aa", Height: 25, Timestamp: 150},
+		{Index: 5, ChannelId: "aa", Height: 40, Timestamp: 300},
+	}
+
+	got := Map(auditWindows(author, patches), func(w *auditWindow) auditWindow { return *w })
+	want := []auditWindow{
+		{Kind: "heights", Start: 10, End: 20, Patches: []int{0}},
+		{Kind: "heights", Start: 30, End: -1, Patches: []int{5}},
+		{Kind: "times", Start: 100, End: 200, Patches: []int{2}},
+		{Kind: "patches", Start: 5, End: -1, Patches: []int{5}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("auditWindows() = %+v, want %+v", got, want)
+	}
+}
+
+func TestVersionEvents(t *testing.T) {
+	prior := &glSettings{Authors: []*glAuthor{
+		{ClaimId: "aa", ChannelName: "@a", Heights: []int64{10}},
+		{ClaimId: "bb", ChannelName: "@b", Heights: []int64{10}},
+	}}
+	next := &glSettings{Authors: []*glAuthor{
+		{ClaimId: "aa", ChannelName: "@a", Heights: []int64{10, pendingHeight}, Ranges: []glRange{{Start: 5, End: -1}}},
+		{ClaimId: "cc", ChannelName: "@c", Heights: []int64{pendingHeight}, Role: roleMaintainer},
+	}}
+	next.resolvePendingHeights(20)
+
+	u := &auditUpdate{Txid: "t2", Height: 20, Op: "update"}
+	got := Map(versionEvents(prior, next, u), func(e *auditEvent) string {
+		return e.Action + " " + e.Detail + " " + e.ChannelId + " " + formatBound("heights", e.Height)
+	})
+	want := []string{
+		"revoke  aa 20",
+		"patches 5- aa 20",
+		"grant  cc 20",
+		"role maintainer cc 20",
+		"remove  bb 20",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("versionEvents() = %q, want %q", got, want)
+	}
+
+	first := versionEvents(nil, prior, &auditUpdate{Txid: "t1", Height: 10, Op: "create"})
+	if len(first) != 2 || first[0].Action != "grant" || first[0].Txid != "t1" {
+		t.Errorf("versionEvents() of first version = %+v", first)
+	}
+}
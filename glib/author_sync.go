@@ -0,0 +1,186 @@
+package glib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The full set of channels that should have access to a repo, kept in a file
+// so the access list can be reviewed like any other config e.g.
+// ```
+// {"authors": [
+//   {"channel": "@alice", "role": "maintainer"},
+//   {"channel": "@bob#ab12", "patches": ["0-499"]}
+// ]}
+// ```
+// Channels not listed lose push access.
+type glAuthorsFile struct {
+	Authors []*glAuthorsFileEntry `json:"authors"`
+}
+
+type glAuthorsFileEntry struct {
+
+	// Channel url, the "lbry://" or "lbry://@" prefix may be omitted
+	Channel string `json:"channel"`
+
+	// rolePusher or roleMaintainer, rolePusher if empty
+	Role string `json:"role,omitempty"`
+
+	// Inclusive patch index ranges e.g. "0-499".  If given the channel may
+	// only publish these patches, otherwise it may push from now on
+	Patches []string `json:"patches,omitempty"`
+}
+
+func readAuthorsFile(path string) (*glAuthorsFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f glAuthorsFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %v", path)
+	}
+
+	// Most likely a mistake, it would revoke everyone
+	if len(f.Authors) == 0 {
+		return nil, errors.Errorf("%v lists no authors", path)
+	}
+	return &f, nil
+}
+
+// An entry of the authors file with its channel resolved
+type desiredAuthor struct {
+	name    string
+	claimId string
+	role    string
+	ranges  []glRange
+}
+
+// Resolves every channel in the file before anything is changed
+func (f *glAuthorsFile) resolve() ([]*desiredAuthor, error) {
+
+	var result []*desiredAuthor
+	seen := map[string]bool{}
+	for _, entry := range f.Authors {
+
+		role := entry.Role
+		if role == "" {
+			role = rolePusher
+		}
+		if !isRole(role) {
+			return nil, errors.Errorf("%v: unknown role %v, expected %v or %v", entry.Channel, role, rolePusher, roleMaintainer)
+		}
+
+		var ranges []glRange
+		for _, x := range entry.Patches {
+			r, err := parseRange(x)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v", entry.Channel)
+			}
+			ranges = append(ranges, r)
+		}
+
+		ch, err := resolveChannel(entry.Channel)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error resolving channel %v.", entry.Channel)
+		}
+		if seen[ch.claimId] {
+			return nil, errors.Errorf("%v is listed more than once", entry.Channel)
+		}
+		seen[ch.claimId] = true
+
+		result = append(result, &desiredAuthor{
+			name:    ch.name,
+			claimId: ch.claimId,
+			role:    role,
+			ranges:  unionRanges(ranges),
+		})
+	}
+	return result, nil
+}
+
+// True if the author may push from now on with no end and without a patch
+// range.  tip is the height at the unix time now
+func (a *glAuthor) canPush(now int64, tip int64) bool {
+	n := len(a.Heights)
+	return n%2 == 1 && a.Heights[n-1] <= tip+1 ||
+		len(a.Times)%2 == 1 && inWindows(a.Times, now)
+}
+
+// Changes the settings so that exactly the desired channels have access.
+// Returns a line describing each change, none if the settings already match
+func (s *glSettings) syncAuthors(desired []*desiredAuthor, now int64, tip int64) []string {
+
+	var changes []string
+	change := func(name string, claimId string, format string, args ...any) {
+		changes = append(changes, fmt.Sprintf("%v:%v ", name, claimId)+fmt.Sprintf(format, args...))
+	}
+	ranges := func(r []glRange) string {
+		if len(r) == 0 {
+			return "none"
+		}
+		return strings.Join(Map(r, glRange.String), ",")
+	}
+
+	listed := map[string]bool{}
+	for _, d := range desired {
+		listed[d.claimId] = true
+
+		author := s.author(d.claimId)
+		push := author != nil && author.canPush(now, tip)
+		role := rolePusher
+		var current []glRange
+		if author != nil {
+			role = author.role()
+			current = author.Ranges
+		}
+
+		// Channels with patch ranges may only publish those patches
+		wantPush := len(d.ranges) == 0
+		if wantPush && !push {
+			change(d.name, d.claimId, "grant")
+			s.grant(d.name, d.claimId, tip+1)
+		}
+		if !wantPush && push {
+			change(d.name, d.claimId, "revoke")
+			s.revoke(d.name, d.claimId, tip+1)
+		}
+
+		if !reflect.DeepEqual(unionRanges(current), d.ranges) {
+			change(d.name, d.claimId, "patches %v -> %v", ranges(current), ranges(d.ranges))
+			s.setRole(d.name, d.claimId, role)
+			s.author(d.claimId).Ranges = d.ranges
+		}
+
+		if role != d.role {
+			change(d.name, d.claimId, "role %v -> %v", role, d.role)
+			s.setRole(d.name, d.claimId, d.role)
+		}
+	}
+
+	for _, author := range s.Authors {
+		if listed[author.ClaimId] {
+			continue
+		}
+		if author.canPush(now, tip) {
+			change(author.ChannelName, author.ClaimId, "revoke")
+			s.revoke(author.ChannelName, author.ClaimId, tip+1)
+		}
+		if len(author.Ranges) > 0 {
+			change(author.ChannelName, author.ClaimId, "patches %v -> none", ranges(author.Ranges))
+			author.Ranges = nil
+		}
+		if author.role() != rolePusher {
+			change(author.ChannelName, author.ClaimId, "role %v -> %v", author.role(), rolePusher)
+			s.setRole(author.ChannelName, author.ClaimId, rolePusher)
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,180 @@
+package glib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Claims from the lbry network remembered between invocations so that a
+// fetch with nothing new only needs to resolve the repo and search for the
+// next patch.  Stored at .glbry/<repohash>/cache.json
+type claimCache struct {
+
+	// The settings claim for the repo as last resolved
+	Repo *cachedClaim `json:"repo"`
+
+	// The txid:nout of the settings claim that settings.json was downloaded
+	// from.  settings.json is re-downloaded when this changes
+	Settings string `json:"settings"`
+
+	// Canonical patch claims in patch index order
+	Patches []*cachedClaim `json:"patches"`
+
+	// Contents of amendments to the settings by txid:nout
+	Amendments map[string]*glAmendment `json:"amendments,omitempty"`
+
+	// Contents of approvals of patches by txid:nout
+	Approvals map[string]*glApproval `json:"approvals,omitempty"`
+
+	// The settings claim's txid:nout and the chain height recovery claims and
+	// amendments were last searched at, see searchKey
+	Searched string `json:"searched,omitempty"`
+
+	// The amendments that search found
+	Found []*cachedAmendment `json:"found,omitempty"`
+
+	// sha1 hashes of applied bundles no claim was found for, so fetches
+	// don't search for them again.  Cleared when the settings change, since
+	// the settings decide which claims are searched
+	Unmatched map[string]bool `json:"unmatched,omitempty"`
+
+}
+
+type cachedClaim struct {
+	ClaimId      string `json:"claim_id"`
+	Txid         string `json:"txid"`
+	Nout         int    `json:"nout"`
+	Height       int    `json:"height"`
+	PermanentUrl string `json:"permanent_url"`
+}
+
+func newCachedClaim(c *sdkClaim) *cachedClaim {
+	return &cachedClaim{
+		ClaimId:      c.ClaimId,
+		Txid:         c.Txid,
+		Nout:         c.Nout,
+		Height:       c.Height,
+		PermanentUrl: c.PermanentUrl,
+	}
+}
+
+func (c *cachedClaim) outpoint() string {
+	return fmt.Sprintf("%v:%v", c.Txid, c.Nout)
+}
+
+func (rh RepoName) cachePath() string {
+	return fmt.Sprintf("%s/cache.json", rh.rootPath())
+}
+
+// Loads the cache, a missing or unreadable cache is treated as empty
+func (rh RepoName) loadCache() *claimCache {
+	var c claimCache
+	b, err := os.ReadFile(rh.cachePath())
+	if err != nil {
+		return &c
+	}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		OutPrintf("ignoring unreadable cache %v", err)
+		return &claimCache{}
+	}
+	return &c
+}
+
+func (rh RepoName) saveCache(c *claimCache) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rh.cachePath(), b, 0666)
+}
+
+// Records the canonical claim for the given patch index, keeping the rest
+func (c *claimCache) fillPatch(index int, claim *cachedClaim) {
+	for len(c.Patches) <= index {
+		c.Patches = append(c.Patches, nil)
+	}
+	c.Patches[index] = claim
+}
+
+// Records the canonical claim for the given patch index, forgetting any
+// later patches which were based on a different claim
+func (c *claimCache) setPatch(index int, claim *cachedClaim) {
+	if index < len(c.Patches) {
+		c.Patches = c.Patches[:index]
+	}
+	for len(c.Patches) < index {
+		c.Patches = append(c.Patches, nil)
+	}
+	c.Patches = append(c.Patches, claim)
+}
+
+// Identifies the chain recovery claims and amendments are searched in.  Only
+// confirmed ones count, so none can appear while the settings claim and the
+// chain height are unchanged.  The height is worked out from the settings
+// claim's confirmations, so empty while it is unconfirmed
+func searchKey(repo *sdkClaim) string {
+	if repo.Height <= 0 || repo.Confirmations <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v@%v", repo.outpoint(), repo.Height+repo.Confirmations-1)
+}
+
+// Loads settings for the repo, only downloading settings.json if the
+// settings claim changed since it was last downloaded
+func (rh RepoName) loadSettings(repo *sdkClaim, cache *claimCache) (*glSettings, error) {
+
+	exists, err := fileExists(rh.settingsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	var settings *glSettings
+	if exists && cache.Settings == repo.outpoint() {
+		OutPrintf("settings unchanged since %v", cache.Settings)
+		settings, err = readSettings(rh.settingsPath())
+	} else {
+		settings, err = downloadSettings(repo.PermanentUrl, rh.settingsPath())
+		cache.Unmatched = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = rh.archiveSettings(repo.outpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	// The claim's height may have changed even if its outpoint didn't, e.g.
+	// it was unconfirmed last time
+	settings.resolvePendingHeights(repo.Height)
+
+	cache.Repo = newCachedClaim(repo)
+	cache.Settings = repo.outpoint()
+	return settings, nil
+}
+
+// Where the copy of the settings downloaded from the given txid:nout is kept
+func (rh RepoName) settingsVersionPath(outpoint string) string {
+	return fmt.Sprintf("%s/settings/%s.json", rh.rootPath(), strings.ReplaceAll(outpoint, ":", "-"))
+}
+
+// Keeps a copy of settings.json for each version of the settings claim, since
+// only the current version can be downloaded from the lbry network.  Read by
+// gitlbry audit
+func (rh RepoName) archiveSettings(outpoint string) error {
+	path := rh.settingsVersionPath(outpoint)
+	exists, err := fileExists(path)
+	if err != nil || exists {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	return copyFile(rh.settingsPath(), path)
+}
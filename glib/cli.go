@@ -1,9 +1,10 @@
 package glib
 
 import (
-	"encoding/json"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,7 +21,7 @@ func CliInit(lbryUrl string) error {
 	}
 
 	// Don't re-create if repo already exists
-	_, err = lbryResolve(lbryUrl)
+	_, err = lbryResolve(c.url)
 	if err == nil {
 		return errors.New("a repo with the given name already exists")
 	}
@@ -33,101 +34,986 @@ func CliInit(lbryUrl string) error {
 	}
 
 	repo := glSettings{
-		Gitlbry: 1,
+		Gitlbry: settingsLayoutVersion,
+		PatchNames: patchNamesLatest,
 		Deleted: []string{},
 		Authors: []*glAuthor{
 			{
 				ClaimId:     user.ClaimId,
 				ChannelName: user.Name,
-				Times:       []int64{time.Now().Unix()},
+				Heights:     []int64{pendingHeight},
 			},
 		},
 	}
 
-	repoBytes, err := json.Marshal(repo)
+	repoBytes, err := encodeSettings(&repo)
+	if err != nil {
+		return err
+	}
+
+	// Make a unique file name
+	tempPath, err := newTempPath()
+	if err != nil {
+		return err
+	}
+
+	// Write temp file to disk
+	err = os.WriteFile(tempPath, repoBytes, 0666)
+	if err != nil {
+		return err
+	}
+
+	// Send temp file to lbry network
+	if c.channel == nil {
+		err = lbryStreamCreate(c.streamName, "0.001", tempPath)
+	} else {
+		err = lbryStreamCreateOnChannel(c.streamName, c.channel.claimId, "0.001", tempPath);
+	}
+	if err != nil {
+		return err;
+	}
+
+	fmt.Println("created")
+	return nil
+}
+
+// Creates a new repo on the lbry network
+func CliAuthorList(lbryUrl string) error {
+	
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return err
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	r, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	r.resolvePendingHeights(claim.height)
+
+	err = loadAmendments(claim.claimId, r, nil)
+	if err != nil {
+		return err
+	}
+
+	if r.isFrozen() {
+		fmt.Println(r.freezeStatus());
+	}
+
+	tip, err := lbryBlockHeight();
+	if err != nil {
+		return err;
+	}
+
+	now := time.Now().Unix()
+	for _, author := range r.Authors {
+		status := author.status(now, tip)
+		ranges := ""
+		if len(author.Ranges) > 0 {
+			ranges = " patches " + strings.Join(Map(author.Ranges, glRange.String), ",")
+		}
+		schedule := author.schedule(now, tip)
+		if schedule != "" {
+			schedule = " " + schedule
+		}
+		fmt.Printf("%v:%v %v %v%v%v\n", author.ChannelName, author.ClaimId, author.role(), status, schedule, ranges)
+	}
+
+	return nil
+
+}
+
+// Creates a new repo on the lbry network
+func CliAuthorModify(lbryUrl string, prefixedChannelUrl []string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	// Pending heights in the current settings are only known once they
+	// confirm
+	if claim.height <= 0 {
+		return errors.New("the last change to the authors is still unconfirmed, try again once it confirms")
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+	
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	now := time.Now().Unix()
+	channelUrls, from, until, err := parseScheduleArgs(prefixedChannelUrl, now);
+	if err != nil {
+		return err;
+	}
+	hasSchedule := len(channelUrls) < len(prefixedChannelUrl);
+
+	// Maintainers can't update the settings, they publish amendments instead
+	if !claim.isMine {
+		if hasSchedule {
+			return errors.New("only the owner can schedule push access with --from and --until");
+		}
+		return amendAuthors(claim, settings, prefixedChannelUrl)
+	}
+
+	// Channels granted by maintainers are only in the amendments, merge
+	// them so the owner's revokes take effect.  The owner's heights win at
+	// equal heights, so the merged amendments merge the same way again
+	err = loadAmendments(claim.claimId, settings, nil);
+	if err != nil {
+		return err;
+	}
+
+	// Grants, revokes and scheduled dates are written as heights
+	tip, err := lbryBlockHeight();
+	if err != nil {
+		return err;
+	}
+	next := tip + 1;
+	fromHeight := scheduleHeight(from, now, tip);
+	untilHeight := scheduleHeight(until, now, tip);
+
+	settings.migrateTimesToHeights(now, tip)
+	for _, x := range channelUrls {
+
+		revoke := strings.HasPrefix(x, "^");
+		url := x;
+		if revoke {
+			url = url[1:]
+		}
+
+		// Optional role or patch range e.g. @bob=maintainer or @alice=0-499
+		url, spec, hasSpec := strings.Cut(url, "=");
+		hasRole := hasSpec && isRole(spec);
+		hasRange := hasSpec && !hasRole;
+		var r glRange;
+		if hasRange {
+			r, err = parseRange(spec);
+			if err != nil {
+				return err;
+			}
+		}
+
+		if hasSchedule && hasSpec {
+			return errors.Errorf("%v: --from and --until can't be used with roles or patch ranges", x);
+		}
+
+		ch, err := resolveChannel(url)
+		if err != nil {
+			return errors.Wrapf(err, "error resolving channel %v.", url)
+		}
+
+		switch {
+		case revoke && hasSchedule:
+			settings.revokeHeights(ch.name, ch.claimId, fromHeight, untilHeight, next);
+		case hasSchedule:
+			author := settings.author(ch.claimId);
+			if author != nil && author.canPush(now, tip) {
+				fmt.Printf("note: %v:%v can already push with no end, revoke it to only allow the scheduled times\n", ch.name, ch.claimId);
+			}
+			settings.grantHeights(ch.name, ch.claimId, fromHeight, untilHeight, next);
+		case revoke && hasRole:
+			settings.setRole(ch.name, ch.claimId, rolePusher);
+		case hasRole:
+			settings.setRole(ch.name, ch.claimId, spec);
+		case revoke && hasRange:
+			settings.revokeRange(ch.name, ch.claimId, r);
+		case hasRange:
+			settings.grantRange(ch.name, ch.claimId, r);
+		case revoke:
+			settings.revoke(ch.name, ch.claimId, next);
+			err = closeRevokedRanges(claim, settings, ch);
+			if err != nil {
+				return err;
+			}
+		default:
+			settings.grant(ch.name, ch.claimId, next);
+		}
+
+	}
+
+	err = saveRepo(*claim, settings);
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ok");
+	return nil;
+}
+
+// Patch ranges allow a channel to publish regardless of revokes, so a revoke
+// also closes them from the first patch nobody has published yet
+func closeRevokedRanges(claim *claim, settings *glSettings, ch *claim) error {
+
+	author := settings.author(ch.claimId);
+	if author == nil || len(author.Ranges) == 0 {
+		return nil;
+	}
+
+	rh, err := NewRepoName(claim.url, claim.permanentUrl);
+	if err != nil {
+		return err;
+	}
+	next, err := firstUnpublishedPatch(rh, settings);
+	if err != nil {
+		return err;
+	}
+
+	settings.closeRanges(ch.claimId, next);
+	if len(author.Ranges) > 0 {
+		fmt.Printf("note: %v:%v may already have published patches %v, delete them to remove them\n", ch.name, ch.claimId, strings.Join(Map(author.Ranges, glRange.String), ","));
+	}
+	return nil;
+}
+
+// Returns the index of the first patch with no claims published under its
+// name.  Patches are published in order, so the index is found by doubling
+// and then bisecting
+func firstUnpublishedPatch(rh RepoName, settings *glSettings) (int64, error) {
+
+	published := func(index int64) (bool, error) {
+		return lbryNameExists(settings.patchName(rh, int(index)));
+	}
+
+	// The first index known to be unpublished
+	hi := int64(1);
+	for {
+		ok, err := published(hi - 1);
+		if err != nil {
+			return 0, err;
+		}
+		if !ok {
+			break;
+		}
+		hi *= 2;
+	}
+
+	// Every patch before lo is published
+	lo := hi / 2;
+	for lo < hi - 1 {
+		mid := (lo + hi) / 2;
+		ok, err := published(mid - 1);
+		if err != nil {
+			return 0, err;
+		}
+		if ok {
+			lo = mid;
+		} else {
+			hi = mid;
+		}
+	}
+	return hi - 1, nil;
+}
+
+// Makes the authors of a repo match the authors file, printing the changes.
+// All changes are made in a single update of the settings
+func CliAuthorSync(lbryUrl string, path string, dryRun bool) error {
+
+	f, err := readAuthorsFile(path);
+	if err != nil {
+		return err;
+	}
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	if !claim.isMine {
+		return errors.New("you do not have permissions to modify the authors")
+	}
+
+	// Pending heights in the current settings are only known once they
+	// confirm
+	if claim.height <= 0 {
+		return errors.New("the last change to the authors is still unconfirmed, try again once it confirms")
+	}
+
+	// Resolve everything before changing anything
+	desired, err := f.resolve();
+	if err != nil {
+		return err;
+	}
+
+	settingsPath, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, settingsPath)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	// Channels granted by maintainers are only in the amendments, merge them
+	// so that channels missing from the file are revoked, as in gitlbry
+	// author
+	err = loadAmendments(claim.claimId, settings, nil);
+	if err != nil {
+		return err;
+	}
+
+	tip, err := lbryBlockHeight();
+	if err != nil {
+		return err;
+	}
+
+	now := time.Now().Unix()
+	settings.migrateTimesToHeights(now, tip)
+	changes := settings.syncAuthors(desired, now, tip);
+
+	if len(changes) == 0 {
+		fmt.Println("no changes");
+		return nil;
+	}
+	for _, c := range changes {
+		fmt.Println(c);
+	}
+
+	if dryRun {
+		return nil;
+	}
+
+	err = saveRepo(*claim, settings);
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ok");
+	return nil;
+}
+
+// Removes --from <date> and --until <date> from the args of gitlbry author.
+// Returns the remaining args and the unix times of the window, from defaults
+// to now and until to math.MaxInt64
+func parseScheduleArgs(args []string, now int64) ([]string, int64, int64, error) {
+
+	var rest []string;
+	from := now;
+	until := int64(math.MaxInt64);
+	for i := 0; i < len(args); i += 1 {
+
+		flag := args[i];
+		if flag != "--from" && flag != "--until" {
+			rest = append(rest, flag);
+			continue;
+		}
+
+		if i + 1 == len(args) {
+			return nil, 0, 0, errors.Errorf("%v expects a date", flag);
+		}
+		i += 1;
+
+		t, err := parseScheduleDate(args[i], flag == "--until");
+		if err != nil {
+			return nil, 0, 0, err;
+		}
+		if flag == "--from" {
+			from = t;
+		} else {
+			until = t;
+		}
+	}
+
+	if until <= from {
+		return nil, 0, 0, errors.New("--until must be after --from");
+	}
+	if until <= now {
+		return nil, 0, 0, errors.New("--until must be in the future");
+	}
+	return rest, from, until, nil;
+}
+
+// Grants and revokes push access as a maintainer by publishing an amendment
+func amendAuthors(claim *claim, settings *glSettings, prefixedChannelUrl []string) error {
+
+	me := loadConfig().Default.PushAs;
+	if me == nil || !settings.isMaintainer(me.ClaimId) {
+		return errors.New("you do not have permissions to modify the authors")
+	}
+
+	amendment := glAmendment{
+		Gitlbry: 1,
+		Settings: claim.claimId,
+	}
+
+	for _, x := range prefixedChannelUrl {
+
+		revoke := strings.HasPrefix(x, "^");
+		url := strings.TrimPrefix(x, "^");
+
+		if strings.Contains(url, "=") {
+			return errors.Errorf("%v: only the owner can change roles and patch ranges", x);
+		}
+
+		ch, err := resolveChannel(url)
+		if err != nil {
+			return errors.Wrapf(err, "error resolving channel %v.", url)
+		}
+
+		if settings.isMaintainer(ch.claimId) {
+			return errors.Errorf("%v is a maintainer, only the owner can change their access", url);
+		}
+
+		author := &glAmendedAuthor{
+			ClaimId: ch.claimId,
+			ChannelName: ch.name,
+		}
+		if revoke {
+			amendment.Revoke = append(amendment.Revoke, author);
+		} else {
+			amendment.Grant = append(amendment.Grant, author);
+		}
+	}
+
+	err := publishAmendment(me.ClaimId, &amendment);
+	if err != nil {
+		return err;
+	}
+
+	fmt.Println("ok");
+	return nil;
+}
+
+// Rewrites the settings of a repo in the latest version of the schema
+func CliUpgrade(lbryUrl string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	if !claim.isMine {
+		return errors.New("you do not have permissions to upgrade the settings")
+	}
+
+	// Saving would lose pending heights in the current settings
+	if claim.height <= 0 {
+		return errors.New("the last change to the settings is still unconfirmed, try again once it confirms")
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	if settings.Gitlbry >= settingsLayoutVersion {
+		fmt.Printf("already version %v\n", settings.Gitlbry);
+		return nil;
+	}
+
+	from := settings.Gitlbry;
+	settings.Gitlbry = settingsLayoutVersion;
+	err = saveRepo(*claim, settings);
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("upgraded from version %v to %v\n", from, settings.Gitlbry);
+	return nil;
+}
+
+// Pseudo-deletes or undeletes patches of a repo.  Patches are given as claim
+// ids or as indices in the local clone of the repo in the current directory
+func CliDelete(lbryUrl string, patches []string, undelete bool) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	if !claim.isMine {
+		return errors.New("you do not have permissions to delete patches")
+	}
+
+	// Saving would lose pending heights in the current settings
+	if claim.height <= 0 {
+		return errors.New("the last change to the settings is still unconfirmed, try again once it confirms")
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	rh, err := NewRepoName(claim.url, claim.permanentUrl);
+	if err != nil {
+		return err;
+	}
+	cache := rh.loadCache();
+
+	for _, x := range patches {
+
+		claimId, err := cache.patchClaimId(x);
+		if err != nil {
+			return err;
+		}
+
+		if undelete {
+			if !settings.isDeleted(claimId) {
+				return errors.Errorf("patch %v is not deleted", x);
+			}
+			settings.undelete(claimId);
+		} else {
+			settings.delete(claimId);
+		}
+	}
+
+	err = saveRepo(*claim, settings);
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ok");
+	return nil;
+}
+
+// Prints the history of a repo's permissions
+func CliAudit(lbryUrl string, asJson bool) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	rh, err := NewRepoName(claim.url, claim.permanentUrl);
+	if err != nil {
+		return err;
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	versions, err := lbryClaimHistory(claim.claimId, claim.txid);
+	if err != nil {
+		return err;
+	}
+
+	log := auditLog{
+		Repo: claim.permanentUrl,
+	}
+
+	// Only the current version can be downloaded by url.  Earlier ones are
+	// read from the copies kept by the local clone if there is one, or else
+	// fetched by the sd_hash in their output
+	var prior *glSettings;
+	for i := len(versions) - 1; i >= 0; i -= 1 {
+		v := versions[i];
+		u := &auditUpdate{
+			Txid: v.Txid,
+			Height: v.Height,
+			Op: v.Op,
+		};
+		log.Updates = append(log.Updates, u);
+
+		versionPath := path;
+		if i > 0 {
+			versionPath, err = rh.settingsVersion(v);
+			if err != nil {
+				OutPrintf("cannot read settings version %v: %v", v.Txid, err);
+				u.Missing = true;
+				continue;
+			}
+		}
+		version, err := readSettings(versionPath);
+		if err != nil {
+			return errors.Wrapf(err, "error reading settings version %v", v.Txid);
+		}
+		version.resolvePendingHeights(v.Height);
+
+		log.Events = append(log.Events, versionEvents(prior, version, u)...);
+		prior = version;
+	}
+
+	amendments, err := findAmendments(claim.claimId, settings, nil);
+	if err != nil {
+		return err;
+	}
+	log.Events = append(log.Events, amendmentEvents(amendments)...);
+	sortEvents(log.Events);
+	settings.applyAmendments(amendments);
+
+	patches, err := findAuditPatches(rh, settings);
+	if err != nil {
+		return err;
+	}
+
+	for _, author := range settings.Authors {
+		log.Authors = append(log.Authors, &auditAuthor{
+			ChannelId: author.ClaimId,
+			ChannelName: author.ChannelName,
+			Role: author.role(),
+			Windows: auditWindows(author, patches),
+		});
+	}
+
+	return log.print(asJson);
+}
+
+// Returns the path of a copy of the version of the settings, either the one
+// kept by the local clone or one fetched by the version's sd_hash
+func (rh RepoName) settingsVersion(v *sdkClaimVersion) (string, error) {
+
+	path := rh.settingsVersionPath(fmt.Sprintf("%v:%v", v.Txid, v.Nout));
+	exists, err := fileExists(path);
+	if err != nil || exists {
+		return path, err;
+	}
+
+	if v.SdHash == "" {
+		return "", errors.New("the claim has no file");
+	}
+	path, err = newTempPath();
+	if err != nil {
+		return "", err;
+	}
+	return path, lbryGetSdHash(v.SdHash, path);
+}
+
+// Prints the description of a repo
+func CliInfo(lbryUrl string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return err
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("repo: %v\n", claim.permanentUrl);
+	if settings.Info != nil {
+		settings.Info.print();
+	}
+	return nil
+}
+
+// Changes the description of a repo given as key=value pairs
+func CliInfoSet(lbryUrl string, pairs []string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	if !claim.isMine {
+		return errors.New("you do not have permissions to change the repo's info")
+	}
+
+	// Saving would lose pending heights in the current settings
+	if claim.height <= 0 {
+		return errors.New("the last change to the settings is still unconfirmed, try again once it confirms")
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	if settings.Info == nil {
+		settings.Info = &glRepoInfo{};
+	}
+
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=");
+		if !ok {
+			return errors.Errorf("invalid %v, expected <key>=<value>", pair);
+		}
+
+		// Store the contact in a form that always resolves to the same channel
+		if key == "contact" && value != "" {
+			ch, err := resolveChannel(value)
+			if err != nil {
+				return errors.Wrapf(err, "error resolving channel %v.", value)
+			}
+			value = fmt.Sprintf("lbry://%v#%v", ch.name, ch.claimId);
+		}
+
+		err = settings.Info.set(key, value);
+		if err != nil {
+			return err;
+		}
+	}
+
+	err = saveRepo(*claim, settings);
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ok");
+	return nil;
+}
+
+// Moves a repo to another channel.  If the channel is in this wallet the
+// repo gets a new settings claim signed by the channel and the old one points
+// to it.  Otherwise the settings claim is sent to the wallet that holds the
+// channel.
+func CliTransfer(lbryUrl string, channelUrl string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	if !claim.isMine {
+		return errors.New("you do not have permissions to transfer the repo")
+	}
+
+	// Saving would lose pending heights in the current settings
+	if claim.height <= 0 {
+		return errors.New("the last change to the settings is still unconfirmed, try again once it confirms")
+	}
+
+	// Someone else's wallet, they become the owner of the same claim
+	if isWalletAddress(channelUrl) {
+		return sendRepo(claim, channelUrl, channelUrl);
+	}
+
+	ch, err := resolveChannel(channelUrl)
+	if err != nil {
+		return errors.Wrapf(err, "error resolving channel %v.", channelUrl)
+	}
+
+	if !ch.isMine {
+		if ch.address == "" {
+			return errors.Errorf("cannot find the wallet address of %v", ch.name);
+		}
+		return sendRepo(claim, ch.address, fmt.Sprintf("the wallet of %v:%v", ch.name, ch.claimId));
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	if settings.Successor != "" {
+		return errors.Errorf("the repo already moved to %v", settings.Successor);
+	}
+
+	// The new settings carry changes made by maintainers, since amendments
+	// are published against the old claim
+	next, err := settings.successorOf(claim.claimId);
+	if err != nil {
+		return err;
+	}
+	err = loadAmendments(claim.claimId, next, nil);
+	if err != nil {
+		return err;
+	}
+
+	nextBytes, err := encodeSettings(next)
+	if err != nil {
+		return err
+	}
+	nextPath, err := newTempPath()
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(nextPath, nextBytes, 0666)
+	if err != nil {
+		return err
+	}
+
+	description := "";
+	if next.Info != nil {
+		description = next.Info.Description;
+	}
+	created, err := lbryStreamCreateForBundle(claim.name, ch.claimId, description, "0.001", nextPath);
+	if err != nil {
+		return err;
+	}
+
+	// Point clones of the old url at the new claim
+	settings.Successor = created.PermanentUrl;
+	err = saveRepo(*claim, settings);
 	if err != nil {
 		return err
 	}
 
-	// Make a unique file name
-	tempPath, err := newTempPath()
+	fmt.Printf("moved to %v\n", created.PermanentUrl);
+	return nil;
+}
+
+// Sends the settings claim to the wallet holding the address.  That wallet
+// can't sign updates with this wallet's channel, so the channel signature is
+// removed and the repo is then only found at its permanent url
+func sendRepo(claim *claim, address string, to string) error {
+
+	err := lbryStreamTransfer(claim.claimId, address, claim.signed);
 	if err != nil {
-		return err
+		return err;
 	}
 
-	// Write temp file to disk
-	err = os.WriteFile(tempPath, repoBytes, 0666)
+	fmt.Printf("sent to %v\n", to);
+	if claim.signed {
+		fmt.Printf("note: the channel signature was removed so the new owner can update the repo.  %v no longer resolves, clones should use %v\n", claim.url, claim.permanentUrl);
+	}
+	return nil;
+}
+
+// Freezes or unfreezes a repo
+func CliFreeze(lbryUrl string, freeze bool) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	if !claim.isMine {
+		return errors.New("you do not have permissions to freeze the repo")
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
 	if err != nil {
 		return err
 	}
 
-	// Send temp file to lbry network
-	if c.channel == nil {
-		err = lbryStreamCreate(c.streamName, "0.001", tempPath)
+	// Freezing can't wait for the last change to confirm.  Its pending
+	// heights are kept and take effect where this update confirms
+	if claim.height > 0 {
+		settings.resolvePendingHeights(claim.height)
+	}
+
+	if freeze == settings.isFrozen() {
+		if freeze {
+			fmt.Printf("already %v\n", settings.freezeStatus());
+		} else {
+			fmt.Println("not frozen");
+		}
+		return nil;
+	}
+
+	if freeze {
+		settings.freeze();
 	} else {
-		err = lbryStreamCreateOnChannel(c.streamName, c.channel.claimId, "0.001", tempPath);
+		settings.unfreeze();
 	}
+
+	err = saveRepo(*claim, settings);
 	if err != nil {
-		return err;
+		return err
 	}
 
-	fmt.Println("created")
-	return nil
+	fmt.Println("ok");
+	return nil;
 }
 
-// Creates a new repo on the lbry network
-func CliAuthorList(lbryUrl string) error {
-	
+// Prints the recovery channels of a repo
+func CliRecoveryList(lbryUrl string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return err
+	}
+
 	path, err := newTempPath();
 	if err != nil {
 		return err;
 	}
 
-	r, err := downloadSettings(lbryUrl, path)
+	settings, err := downloadSettings(claim.url, path)
 	if err != nil {
 		return err
 	}
 
-	now := time.Now().Unix()
-	for _, author := range r.Authors {
-		status := "revoked"
-		if hasPermission(author, now) {
-			status = "granted"
-		}
-		fmt.Printf("%v:%v %v\n", author.ChannelName, author.ClaimId, status)
+	for _, r := range settings.Recovery {
+		fmt.Printf("%v:%v\n", r.ChannelName, r.ClaimId);
 	}
 
 	return nil
-
 }
 
-// Creates a new repo on the lbry network
-func CliAuthorModify(lbryUrl string, prefixedChannelUrl []string) error {
+// Adds and removes recovery channels of a repo
+func CliRecoveryModify(lbryUrl string, prefixedChannelUrl []string) error {
 
 	claim, err := resolveStream(lbryUrl)
 	if err != nil {
-		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo");
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
 	}
+
 	if !claim.isMine {
-		return errors.New("you do not have permissions to modify the authors")
+		return errors.New("you do not have permissions to change the recovery channels")
+	}
+
+	// Saving would lose pending heights in the current settings
+	if claim.height <= 0 {
+		return errors.New("the last change to the settings is still unconfirmed, try again once it confirms")
 	}
 
 	path, err := newTempPath();
 	if err != nil {
 		return err;
 	}
-	
-	settings, err := downloadSettings(lbryUrl, path)
+
+	settings, err := downloadSettings(claim.url, path)
 	if err != nil {
 		return err
 	}
+	settings.resolvePendingHeights(claim.height)
 
-	now := time.Now().Unix()
+	hadRecovery := len(settings.Recovery) > 0;
 	for _, x := range prefixedChannelUrl {
 
-		revoke := strings.HasPrefix(x, "^");
+		remove := strings.HasPrefix(x, "^");
 		url := x;
-		if revoke {
+		if remove {
 			url = url[1:]
 		}
 
@@ -136,12 +1022,269 @@ func CliAuthorModify(lbryUrl string, prefixedChannelUrl []string) error {
 			return errors.Wrapf(err, "error resolving channel %v.", url)
 		}
 
-		if revoke {
-			settings.revoke(ch.name, ch.claimId, now);
+		// A recovery channel in the same wallet is compromised with it
+		if !remove && ch.isMine {
+			fmt.Printf("note: %v:%v is in this wallet, a recovery channel's key should be kept elsewhere\n", ch.name, ch.claimId);
+		}
+
+		if remove {
+			settings.removeRecovery(ch.claimId);
 		} else {
-			settings.grant(ch.name, ch.claimId, now);
+			settings.addRecovery(ch.name, ch.claimId);
+		}
+	}
+
+	err = saveRepo(*claim, settings);
+	if err != nil {
+		return err
+	}
+
+	if hadRecovery {
+		fmt.Println("note: existing clones keep the recovery channels they first saw");
+	}
+	fmt.Println("ok");
+	return nil;
+}
+
+// Publishes the settings in the given file as a recovery claim signed by a
+// recovery channel.  Clones that pinned the channel switch to it on their
+// next fetch
+func CliRecover(lbryUrl string, channelUrl string, settingsPath string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	ch, err := resolveChannel(channelUrl)
+	if err != nil {
+		return errors.Wrapf(err, "error resolving channel %v.", channelUrl)
+	}
+	if !ch.isMine {
+		return errors.Errorf("%v:%v is not in this wallet", ch.name, ch.claimId);
+	}
+
+	trusted, err := readSettings(settingsPath)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %v", settingsPath);
+	}
+	if !trusted.isRecovery(ch.claimId) {
+		return errors.Errorf("%v:%v is not a recovery channel in %v", ch.name, ch.claimId, settingsPath);
+	}
+
+	// The settings being replaced, after any moves made with the
+	// compromised channel
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+	current, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	_, currentId, err := followSuccessors(claim.claimId, current);
+	if err != nil {
+		return err;
+	}
+
+	origin := trusted.origin(claim.claimId);
+	next, err := trusted.recoveryOf(origin, currentId);
+	if err != nil {
+		return err;
+	}
+
+	nextBytes, err := encodeSettings(next)
+	if err != nil {
+		return err
+	}
+	nextPath, err := newTempPath()
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(nextPath, nextBytes, 0666)
+	if err != nil {
+		return err
+	}
+
+	created, err := lbryStreamCreateForBundle(recoverName(origin), ch.claimId, "", "0.001", nextPath);
+	if err != nil {
+		return err;
+	}
+
+	fmt.Printf("recovered to %v\n", created.PermanentUrl);
+	return nil;
+}
+
+// Prints the patch waiting for approvals as found by the last fetch
+func CliApproveList(lbryUrl string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return err
+	}
+
+	rh, err := NewRepoName(claim.url, claim.permanentUrl);
+	if err != nil {
+		return err;
+	}
+	sync, err := rh.loadSync();
+	if err != nil {
+		return errors.Wrapf(err, "error loading %v, fetch first", rh.syncPath());
+	}
+
+	if p := sync.Pending; p != nil {
+		fmt.Printf("patch %v %v has %v of %v approvals, approvals count until block %v\n", sync.DownloadIndex, p.PermanentUrl, p.Approvals, p.Required, p.Deadline);
+	}
+	return nil;
+}
+
+// Approves the pending patch with the given index as the current channel,
+// which must be a maintainer.  The patch must have been found by a fetch
+func CliApprove(lbryUrl string, patch string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	index, err := strconv.Atoi(patch);
+	if err != nil || index < 0 {
+		return errors.Errorf("invalid patch %v, expected a patch index", patch);
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.permanentUrl, path)
+	if err != nil {
+		return err
+	}
+
+	rh, err := NewRepoName(claim.url, claim.permanentUrl);
+	if err != nil {
+		return err;
+	}
+
+	// Approve as the channel pushes to this remote are made as
+	me, err := rh.options.pushAs(loadConfig());
+	if err != nil {
+		return err;
+	}
+	if !settings.isMaintainer(me.ClaimId) {
+		return errors.Errorf("only maintainers can approve patches, %v:%v is not a maintainer", me.Name, me.ClaimId)
+	}
+
+	sync, err := rh.loadSync();
+	if err != nil {
+		return errors.Wrapf(err, "error loading %v, fetch first", rh.syncPath());
+	}
+
+	pending := sync.Pending;
+	if pending == nil || sync.DownloadIndex != index {
+		return errors.Errorf("patch %v is not waiting for approvals, fetch first to check", index);
+	}
+	if pending.ChannelId == me.ClaimId {
+		return errors.New("you can't approve your own patch");
+	}
+
+	err = publishApproval(me.ClaimId, &glApproval{
+		Gitlbry: 1,
+		Patch: pending.ClaimId,
+		Sha1: pending.Sha1,
+	});
+	if err != nil {
+		return err;
+	}
+
+	fmt.Println("ok");
+	return nil;
+}
+
+// Prints the ref rules of a repo
+func CliProtectList(lbryUrl string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return err
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range settings.currentRefRules() {
+		fmt.Println(rule.String());
+	}
+
+	return nil
+}
+
+// Adds, replaces and removes ref rules of a repo
+func CliProtectModify(lbryUrl string, prefixedRules []string) error {
+
+	claim, err := resolveStream(lbryUrl)
+	if err != nil {
+		return	errors.Wrapf(err, "error resolving %v.  The url may be malformed or may not reference a git repo", lbryUrl);
+	}
+
+	if !claim.isMine {
+		return errors.New("you do not have permissions to change the ref rules")
+	}
+
+	// Saving would lose pending heights in the current settings
+	if claim.height <= 0 {
+		return errors.New("the last change to the settings is still unconfirmed, try again once it confirms")
+	}
+
+	path, err := newTempPath();
+	if err != nil {
+		return err;
+	}
+
+	settings, err := downloadSettings(claim.url, path)
+	if err != nil {
+		return err
+	}
+	settings.resolvePendingHeights(claim.height)
+
+	for _, x := range prefixedRules {
+
+		// ^<pattern> removes the rule
+		if strings.HasPrefix(x, "^") {
+			pattern := x[1:];
+			if settings.refRule(pattern) == nil {
+				return errors.Errorf("no rule for %v", pattern);
+			}
+			settings.removeRefRule(pattern);
+			continue;
+		}
+
+		rule, channels, err := parseRefRule(x);
+		if err != nil {
+			return err;
 		}
 
+		for _, url := range channels {
+			ch, err := resolveChannel(url)
+			if err != nil {
+				return errors.Wrapf(err, "error resolving channel %v.", url)
+			}
+			rule.Authors = append(rule.Authors, ch.claimId);
+		}
+
+		if rule.Approvals > len(settings.maintainerIds()) {
+			fmt.Printf("note: %v needs %v approvals but the repo has %v maintainers\n", rule.Ref, rule.Approvals, len(settings.maintainerIds()));
+		}
+
+		settings.setRefRule(rule);
 	}
 
 	err = saveRepo(*claim, settings);
@@ -200,32 +1343,9 @@ func CliMeSet(channelUrl string) error {
 
 
 
-func hasPermission(author *glAuthor, time int64) bool {
-
-	n := len(author.Times)
-
-	i := 0
-	for ; i+1 < n; i += 2 {
-		start := author.Times[i]
-		end := author.Times[i+1]
-		if start <= time && time < end {
-			return true
-		}
-	}
-
-	if i == n-1 {
-		start := author.Times[i]
-		return start <= time
-	}
-
-	return false
-
-}
-
-
 func saveRepo(claim claim, repo *glSettings) error {
 
-	repoBytes, err := json.Marshal(repo)
+	repoBytes, err := encodeSettings(repo)
 	if err != nil {
 		return err
 	}
@@ -242,19 +1362,34 @@ func saveRepo(claim claim, repo *glSettings) error {
 		return err
 	}
 
-	// Send temp file to lbry network
-	return lbryStreamUpdate(claim.claimId, tempPath)
+	// Send temp file to lbry network, keeping the claim's metadata in sync
+	// with the repo's info
+	var meta *sdkStreamMeta;
+	if repo.Info != nil {
+		meta = repo.Info.streamMeta(claim.name);
+	}
+	return lbryStreamUpdate(claim.claimId, tempPath, meta)
 
 }
 
 type claim struct {
 	url string
+	permanentUrl string
+	txid string
+	address string
 	name string
 	claimId string
 	isMine bool
+
+	// Block height of the claim, zero or negative if unconfirmed
+	height int
+
+	// True if the claim is signed by a channel
+	signed bool
 }
 
 type newStreamClaim struct {
+	url string
 	channel *claim
 	streamName string
 }
@@ -274,6 +1409,7 @@ func resolveChannel(niceUrl string) (*claim, error) {
 	if hasStream {
 		return nil, errors.New("Expected Channel Url, got Stream Url");
 	}
+	url = u.String();
 
 	// Resolve on lbry network
 	c, err := lbryResolve(url);
@@ -281,15 +1417,21 @@ func resolveChannel(niceUrl string) (*claim, error) {
 		return nil, err;
 	}
 
+	isMine, err := c.isMine();
 	if err != nil {
 		return nil, err;
 	}
 
 	return &claim{
 		url: url,
+		permanentUrl: c.PermanentUrl,
+		txid: c.Txid,
+		address: c.Address,
 		name: c.NormalizedName,
 		claimId: c.ClaimId,
-		isMine: *c.IsMyOutput,
+		isMine: isMine,
+		height: c.Height,
+		signed: c.SigningChannel != nil,
 	}, nil;
 
 }
@@ -312,13 +1454,14 @@ func resolveNewStream(niceUrl string) (*newStreamClaim, error) {
 	}
 
 	// Get Url for just the channel
-	chName, hasChannel := u.ChannelWithModifiers();
+	chUrl, hasChannel := u.ChannelUrl();
 	if !hasChannel {
 		return &newStreamClaim{
+			url: u.String(),
 			streamName: streamName,
 		}, nil;
 	}
-	channeUrl := "lbry://@" + chName;
+	channeUrl := chUrl.String();
 
 
 	// Resolve on lbry network
@@ -327,17 +1470,20 @@ func resolveNewStream(niceUrl string) (*newStreamClaim, error) {
 		return nil, err;
 	}
 
+	isMine, err := ch.isMine();
 	if err != nil {
 		return nil, err;
 	}
 
 	return &newStreamClaim{
+		url: u.String(),
 		streamName: streamName,
 		channel: &claim{
 			url: channeUrl,
 			name: ch.NormalizedName,
 			claimId: ch.ClaimId,
-			isMine: *ch.IsMyOutput,
+			isMine: isMine,
+			height: ch.Height,
 		},
 	}, nil;
 
@@ -357,28 +1503,38 @@ func resolveStream(niceUrl string) (*claim, error) {
 	if !isStream {
 		return nil, errors.New("expected stream url, got channel url")
 	}
+	url = u.String();
 
-	// Resolve on lbry network
-	c, err := lbryResolve(url);
+	// Resolve on lbry network.  Remote options such as ?channel= aren't
+	// part of the claim's url
+	c, err := lbryResolve(u.WithoutQuery().String());
 	if err != nil {
 		return nil, err;
 	}
 
+	isMine, err := c.isMine();
 	if err != nil {
 		return nil, err;
 	}
 
 	return &claim{
 		url: url,
+		permanentUrl: c.PermanentUrl,
+		txid: c.Txid,
+		address: c.Address,
 		name: c.NormalizedName,
 		claimId: c.ClaimId,
-		isMine: *c.IsMyOutput,
+		isMine: isMine,
+		height: c.Height,
+		signed: c.SigningChannel != nil,
 	}, nil;
 
 }
 
 func prefixNiceChannel(niceUrl string) string {
 
+	niceUrl = fromGatewayUrl(niceUrl, loadConfig().gatewayHosts());
+
 	if strings.HasPrefix(niceUrl, "lbry://@") {
 		return niceUrl
 	};
@@ -392,6 +1548,8 @@ func prefixNiceChannel(niceUrl string) string {
 
 func prefixNice(niceUrl string) string {
 
+	niceUrl = fromGatewayUrl(niceUrl, loadConfig().gatewayHosts());
+
 	if strings.HasPrefix(niceUrl, "lbry://") {
 		return niceUrl
 	};
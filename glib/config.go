@@ -12,12 +12,30 @@ type glConfig struct {
 
 	// Configuration for idividual repositories
 	ByUrl map[string]glRepoConfig;
+
+	// Hosts of web frontends whose links are accepted in place of lbry urls,
+	// nil uses defaultGatewayHosts
+	GatewayHosts []string;
 }
 
+// Web frontends recognized when GatewayHosts is not configured
+var defaultGatewayHosts = []string{
+	"odysee.com",
+	"lbry.tv",
+	"open.lbry.com",
+};
+
 type glRepoConfig struct {
 	PushAs *glChannel
+
+	// Number of confirmations to wait for after publishing a patch.  nil
+	// uses the default
+	Confirmations *int
 }
 
+// Confirmations to wait for after a push when none are configured
+const defaultConfirmations = 1;
+
 type glChannel struct {
 	ClaimId string
 	Name string 
@@ -51,6 +69,25 @@ func loadConfig() *glConfig {
 	return result;
 }
 
+// Returns the number of confirmations to wait for after pushing to the
+// repo at lbryUrl.  Per repo settings take precedence over the defaults
+func (c *glConfig) confirmations(lbryUrl string) int {
+	if rc, ok := c.ByUrl[lbryUrl]; ok && rc.Confirmations != nil {
+		return *rc.Confirmations;
+	}
+	if c.Default.Confirmations != nil {
+		return *c.Default.Confirmations;
+	}
+	return defaultConfirmations;
+}
+
+func (c *glConfig) gatewayHosts() []string {
+	if c.GatewayHosts == nil {
+		return defaultGatewayHosts;
+	}
+	return c.GatewayHosts;
+}
+
 func (c *glConfig) save() error {
 
 	// Convert to json
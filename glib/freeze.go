@@ -0,0 +1,80 @@
+package glib
+
+import (
+	"fmt"
+	"math"
+)
+
+// A past freeze.  Patches at heights From through Until-1 are ignored
+type glFreeze struct {
+	From  int64 `json:"from"`
+	Until int64 `json:"until"`
+}
+
+// Stops accepting patches from the height at which this settings update
+// confirms
+func (s *glSettings) freeze() {
+	if s.FrozenAt == 0 {
+		s.FrozenAt = pendingHeight
+	}
+}
+
+// Accepts patches again from the height at which this settings update
+// confirms
+func (s *glSettings) unfreeze() {
+	if s.FrozenAt == 0 {
+		return
+	}
+	s.Freezes = append(s.Freezes, &glFreeze{From: s.FrozenAt, Until: pendingHeight})
+	s.FrozenAt = 0
+}
+
+// See resolvePendingHeights
+func (s *glSettings) resolvePendingFreezes(h int64) {
+	if s.FrozenAt == pendingHeight {
+		s.FrozenAt = h
+	}
+	for _, f := range s.Freezes {
+		if f.From == pendingHeight {
+			f.From = h
+		}
+		if f.Until == pendingHeight {
+			f.Until = h
+		}
+	}
+}
+
+// True if the repo is frozen or a freeze is pending
+func (s *glSettings) isFrozen() bool {
+	return s.FrozenAt != 0
+}
+
+// True if a patch at the given height is ignored because the repo was frozen.
+// Unconfirmed patches (height <= 0) are ignored while a freeze or unfreeze is
+// unconfirmed too, as they may confirm on either side of it
+func (s *glSettings) isFrozenAt(height int64) bool {
+	if height <= 0 {
+		height = math.MaxInt64
+	}
+	if s.FrozenAt != 0 && s.FrozenAt <= height {
+		return true
+	}
+	for _, f := range s.Freezes {
+		if f.From <= height && (height < f.Until || f.Until == math.MaxInt64) {
+			return true
+		}
+	}
+	return false
+}
+
+// Describes the freeze for display, empty if the repo isn't frozen
+func (s *glSettings) freezeStatus() string {
+	switch {
+	case s.FrozenAt == 0:
+		return ""
+	case s.FrozenAt == math.MaxInt64 || s.FrozenAt == pendingHeight:
+		return "freeze pending"
+	default:
+		return fmt.Sprintf("frozen at height %v", s.FrozenAt)
+	}
+}
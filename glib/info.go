@@ -0,0 +1,92 @@
+package glib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Describes the repo to people browsing for it.  Kept in the settings so
+// readers get it without cloning, and copied to the settings claim's title,
+// description and tags so the repo can be found on the lbry network
+type glRepoInfo struct {
+	Description   string   `json:"description,omitempty"`
+	License       string   `json:"license,omitempty"`
+	Homepage      string   `json:"homepage,omitempty"`
+	DefaultBranch string   `json:"default_branch,omitempty"`
+	Topics        []string `json:"topics,omitempty"`
+
+	// Url of the channel to contact the maintainers through
+	Contact string `json:"contact,omitempty"`
+}
+
+// Keys accepted by gitlbry info --set in display order
+var infoKeys = []string{"description", "license", "homepage", "default_branch", "topics", "contact"}
+
+// Tag added to every repo's settings claim
+const repoTag = "gitlbry"
+
+// Sets the field with the given key, an empty value clears it.  Topics are
+// comma separated
+func (i *glRepoInfo) set(key string, value string) error {
+	switch key {
+	case "description":
+		i.Description = value
+	case "license":
+		i.License = value
+	case "homepage":
+		i.Homepage = value
+	case "default_branch":
+		i.DefaultBranch = value
+	case "topics":
+		i.Topics = nil
+		for _, topic := range strings.Split(value, ",") {
+			topic = strings.ToLower(strings.TrimSpace(topic))
+			if topic != "" {
+				i.Topics = append(i.Topics, topic)
+			}
+		}
+	case "contact":
+		i.Contact = value
+	default:
+		return errors.Errorf("unknown info %v, expected one of %v", key, strings.Join(infoKeys, ", "))
+	}
+	return nil
+}
+
+func (i *glRepoInfo) get(key string) string {
+	switch key {
+	case "description":
+		return i.Description
+	case "license":
+		return i.License
+	case "homepage":
+		return i.Homepage
+	case "default_branch":
+		return i.DefaultBranch
+	case "topics":
+		return strings.Join(i.Topics, ",")
+	case "contact":
+		return i.Contact
+	}
+	return ""
+}
+
+func (i *glRepoInfo) print() {
+	for _, key := range infoKeys {
+		value := i.get(key)
+		if value != "" {
+			fmt.Printf("%v: %v\n", key, value)
+		}
+	}
+}
+
+// The claim metadata for a repo with the given name
+func (i *glRepoInfo) streamMeta(name string) *sdkStreamMeta {
+	return &sdkStreamMeta{
+		Title:       name,
+		Description: i.Description,
+		Tags:        append([]string{repoTag}, i.Topics...),
+	}
+}
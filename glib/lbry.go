@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -68,18 +68,19 @@ func (c *withError) GetError() error {
 type sdkClaim struct {
 	withError
 
-	//Address        string `json:"address"`
+	Address        string `json:"address"`
 	//Amount         string `json:"amount"`
 	//CanonicalUrl   string `json:"canonical_url"`
-	ClaimId string `json:"claim_id"`
-	//Height         int    `json:"height"`
-	//Name           string `json:"name"`
+	ClaimId        string `json:"claim_id"`
+	Confirmations  int    `json:"confirmations"`
+	Height         int    `json:"height"`
+	Name           string `json:"name"`
 	NormalizedName string `json:"normalized_name"`
-	//Nout           int    `json:"nout"`
-	//PermanentUrl   string `json:"permanent_url"`
+	Nout           int    `json:"nout"`
+	PermanentUrl   string `json:"permanent_url"`
 	//ShortUrl       string `json:"short_url"`
-	//Timestamp      int    `json:"timestamp"`
-	//Txid           string `json:"txid"`
+	Timestamp int64  `json:"timestamp"`
+	Txid      string `json:"txid"`
 	//Type           string `json:"type"`
 	//Value interface{}
 	// determines the type of the 'value' field: 'channel', 'stream', etc"
@@ -91,16 +92,26 @@ type sdkClaim struct {
 	IsMyOutput *bool `json:"is_my_output"`
 }
 
+// Identifies the exact version of a claim.  Changes every time the claim
+// is updated
+func (c *sdkClaim) outpoint() string {
+	return fmt.Sprintf("%v:%v", c.Txid, c.Nout)
+}
+
 func lbryResolve(url string) (*sdkClaim, error) {
 
+	_, err := lbrySdk()
+	if err != nil {
+		return nil, err
+	}
+
 	type arg struct {
-		// This is plural the server accepts both a single string or a list of strings
-		Urls              string `json:"urls"`
-		IncludeIsMyOutput bool   `json:"include_is_my_output"`
+		Urls              []string `json:"urls"`
+		IncludeIsMyOutput bool     `json:"include_is_my_output"`
 	}
 
 	result, err := rpcCall[arg, map[string]sdkClaim]("resolve", arg{
-		Urls:              url,
+		Urls:              []string{url},
 		IncludeIsMyOutput: true,
 	})
 
@@ -153,6 +164,16 @@ func lbryGet(uri string, fileName string) error {
 		return errors.New("error reading document. It may been changed recently.  Try again in about 1 minute. %v")
 	}
 
+	// Recordings carry the file contents since the daemon's download path
+	// won't exist when replaying
+	if isReplaying() {
+		return rpcReplayFile(fileName)
+	}
+	err = rpcRecordFile(o.DownloadPath)
+	if err != nil {
+		return err
+	}
+
 	// Move file to where we want it.  Note we can ask the lbry server do to this but it uses
 	// a different root path.  Easier to handle this way
 	err = os.Rename(o.DownloadPath, fileName);
@@ -169,12 +190,25 @@ type lbryChannel struct {
 	id   string
 }
 
-func lbryStreamUpdate(claimId string, filePath string) error {
+// Title, description and tags of a stream claim
+type sdkStreamMeta struct {
+	Title       string
+	Description string
+	Tags        []string
+}
+
+// Replaces the stream's file.  If meta is given the claim's title,
+// description and tags are replaced too, otherwise they are left unchanged
+func lbryStreamUpdate(claimId string, filePath string, meta *sdkStreamMeta) error {
 
 	type arg struct {
-		ClaimId  string `json:"claim_id"`
-		FilePath string `json:"file_path"`
-		Blocking bool   `json:"blocking"`
+		ClaimId     string   `json:"claim_id"`
+		FilePath    string   `json:"file_path"`
+		Blocking    bool     `json:"blocking"`
+		Title       *string  `json:"title,omitempty"`
+		Description *string  `json:"description,omitempty"`
+		Tags        []string `json:"tags,omitempty"`
+		ClearTags   bool     `json:"clear_tags,omitempty"`
 	}
 
 	type out struct {
@@ -182,11 +216,22 @@ func lbryStreamUpdate(claimId string, filePath string) error {
 		// Not interested in any of the outputs
 	}
 
-	o, err := rpcCall[arg, out]("stream_update", arg{
+	a := arg{
 		FilePath: filePath,
 		ClaimId:  claimId,
 		Blocking: true,
-	})
+	}
+	if meta != nil {
+		a.Title = &meta.Title
+		a.Description = &meta.Description
+		a.Tags = meta.Tags
+		a.ClearTags = true
+	}
+
+	o, err := rpcCall[arg, out]("stream_update", a)
+	if err != nil {
+		return err
+	}
 
 	err = o.GetError()
 	if err != nil {
@@ -218,6 +263,9 @@ func lbryStreamCreateOnChannel(name string, channelId string, bid string, filePa
 		ChannelId: channelId,
 		Blocking:  true,
 	})
+	if err != nil {
+		return err
+	}
 
 	err = o.GetError()
 	if err != nil {
@@ -227,7 +275,34 @@ func lbryStreamCreateOnChannel(name string, channelId string, bid string, filePa
 	return err
 }
 
-func lbryStreamCreateForBundle(name string, channelId string, description string, bid string, filePath string) error {
+// The transaction returned by the sdk for stream_create, stream_update etc.
+type sdkTransaction struct {
+	withError
+	Txid    string       `json:"txid"`
+	Height  int          `json:"height"`
+	Outputs []*sdkOutput `json:"outputs"`
+}
+
+type sdkOutput struct {
+	ClaimId      string `json:"claim_id"`
+	PermanentUrl string `json:"permanent_url"`
+	Nout         int    `json:"nout"`
+}
+
+// Returns the first output of the transaction that created or updated a claim
+func (t *sdkTransaction) claimOutput() (*sdkOutput, error) {
+	for _, output := range t.Outputs {
+		if output.ClaimId != "" {
+			return output, nil
+		}
+	}
+	return nil, errors.New("sdk transaction did not contain a claim output")
+}
+
+// Publishes a bundle file, or any file signed by the channel, and returns the
+// claim that was created.  Blocks only until the transaction is broadcast,
+// not until it is confirmed.
+func lbryStreamCreateForBundle(name string, channelId string, description string, bid string, filePath string) (*sdkOutput, error) {
 
 	type arg struct {
 		Name        string `json:"name"`
@@ -238,25 +313,275 @@ func lbryStreamCreateForBundle(name string, channelId string, description string
 		Blocking    bool   `json:"blocking"`
 	}
 
+	o, err := rpcCall[arg, sdkTransaction]("stream_create", arg{
+		Name:        name,
+		Bid:         bid,
+		FilePath:    filePath,
+		ChannelId:   channelId,
+		Description: description,
+		Blocking:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.GetError()
+	if err != nil {
+		return nil, err
+	}
+
+	return o.claimOutput()
+}
+
+// Returns the unspent streams with the given name owned by this wallet that
+// have not yet been confirmed, e.g. a patch from an earlier push that is still
+// in the mem-pool.
+func lbryMyUnconfirmedStreams(name string) ([]*sdkOutput, error) {
+
+	_, err := lbrySdk()
+	if err != nil {
+		return nil, err
+	}
+
+	type arg struct {
+		Type       string `json:"type"`
+		Name       string `json:"name"`
+		IsNotSpent bool   `json:"is_not_spent"`
+		IsMyOutput bool   `json:"is_my_output"`
+		PageSize   int    `json:"page_size"`
+	}
+
 	type out struct {
-		withError
-		// Not interested in any of the outputs other than error
+		sdkOutput
+		Height int `json:"height"`
 	}
 
-	o, err := rpcCall[arg, out]("stream_create", arg{
-		Name:      name,
-		Bid:       bid,
-		FilePath:  filePath,
-		ChannelId: channelId,
-		Blocking:  true,
+	page, err := rpcCall[arg, sdkPage[*out]]("txo_list", arg{
+		Type:       "stream",
+		Name:       name,
+		IsNotSpent: true,
+		IsMyOutput: true,
+		PageSize:   5000,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*sdkOutput
+	for _, item := range page.Items {
+		if item.Height <= 0 {
+			result = append(result, &item.sdkOutput)
+		}
+	}
+	return result, nil
+}
+
+// Returns the height of the newest block the wallet knows of
+func lbryBlockHeight() (int64, error) {
+
+	type arg struct{}
+
+	type wallet struct {
+		Blocks int64 `json:"blocks"`
+	}
+
+	type out struct {
+		withError
+		Wallet *wallet `json:"wallet"`
+	}
 
+	o, err := rpcCall[arg, out]("status", arg{})
+	if err != nil {
+		return 0, err
+	}
 	err = o.GetError()
+	if err != nil {
+		return 0, err
+	}
+	if o.Wallet == nil {
+		return 0, errors.New("the lbry wallet isn't started yet, try again in a minute")
+	}
+	return o.Wallet.Blocks, nil
+}
+
+// Returns true if any claim has been published with the given name
+func lbryNameExists(name string) (bool, error) {
+
+	type arg struct {
+		Name     string `json:"name"`
+		PageSize int    `json:"page_size"`
+	}
+
+	page, err := rpcCall[arg, sdkPage[*sdkClaim]]("claim_search", arg{
+		Name:     name,
+		PageSize: 1,
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(page.Items) > 0, nil
+}
+
+// Sends the stream claim to the given address, handing it to whichever
+// wallet holds that address.  clearChannel removes the claim's channel
+// signature, which the receiving wallet couldn't renew when it updates the
+// claim
+func lbryStreamTransfer(claimId string, address string, clearChannel bool) error {
+
+	type arg struct {
+		ClaimId      string `json:"claim_id"`
+		ClaimAddress string `json:"claim_address"`
+		ClearChannel bool   `json:"clear_channel,omitempty"`
+		Blocking     bool   `json:"blocking"`
+	}
+
+	type out struct {
+		withError
+		// Not interested in any of the outputs
+	}
+
+	o, err := rpcCall[arg, out]("stream_update", arg{
+		ClaimId:      claimId,
+		ClaimAddress: address,
+		ClearChannel: clearChannel,
+		Blocking:     true,
+	})
 	if err != nil {
 		return err
 	}
 
-	return err
+	return o.GetError()
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// True if x looks like a lbry wallet address.  Addresses are 34 base58
+// characters starting with b, or r for script addresses
+func isWalletAddress(x string) bool {
+	if len(x) != 34 || (x[0] != 'b' && x[0] != 'r') {
+		return false
+	}
+	for _, r := range x {
+		if !strings.ContainsRune(base58Alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// A version of a claim as created or updated by a transaction
+type sdkClaimVersion struct {
+	Txid   string
+	Nout   int
+	Height int
+
+	// "create" or "update"
+	Op string
+
+	// sd_hash of the version's file, empty if it has none
+	SdHash string
+}
+
+// Returns every version of the claim, newest first, by following the inputs
+// of the transaction that made each version back to the one that created
+// the claim.  txid is the transaction of the current version
+func lbryClaimHistory(claimId string, txid string) ([]*sdkClaimVersion, error) {
+
+	type arg struct {
+		Txid string `json:"txid"`
+	}
+
+	type in struct {
+		Txid string `json:"txid"`
+		Nout int    `json:"nout"`
+	}
+
+	type source struct {
+		SdHash string `json:"sd_hash"`
+	}
+
+	type value struct {
+		Source *source `json:"source"`
+	}
+
+	type out struct {
+		ClaimId string `json:"claim_id"`
+		ClaimOp string `json:"claim_op"`
+		Nout    int    `json:"nout"`
+		Value   *value `json:"value"`
+	}
+
+	type tx struct {
+		withError
+		Height  int    `json:"height"`
+		Inputs  []*in  `json:"inputs"`
+		Outputs []*out `json:"outputs"`
+	}
+
+	show := func(txid string) (*tx, error) {
+		t, err := rpcCall[arg, tx]("transaction_show", arg{Txid: txid})
+		if err != nil {
+			return nil, err
+		}
+		return &t, t.GetError()
+	}
+
+	// The output of t for the claim, nil if it has none
+	claimOutput := func(t *tx) *out {
+		for _, o := range t.Outputs {
+			if o.ClaimId == claimId {
+				return o
+			}
+		}
+		return nil
+	}
+
+	t, err := show(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*sdkClaimVersion
+	for {
+
+		o := claimOutput(t)
+		if o == nil {
+			return nil, errors.Errorf("transaction %v has no output for claim %v", txid, claimId)
+		}
+
+		v := &sdkClaimVersion{
+			Txid:   txid,
+			Nout:   o.Nout,
+			Height: t.Height,
+			Op:     o.ClaimOp,
+		}
+		if o.Value != nil && o.Value.Source != nil {
+			v.SdHash = o.Value.Source.SdHash
+		}
+		result = append(result, v)
+		if o.ClaimOp == "create" {
+			return result, nil
+		}
+
+		// An update spends the previous version, usually as the first input
+		var prior *tx
+		for _, i := range t.Inputs {
+			p, err := show(i.Txid)
+			if err != nil {
+				return nil, err
+			}
+			po := claimOutput(p)
+			if po != nil && po.Nout == i.Nout {
+				prior = p
+				txid = i.Txid
+				break
+			}
+		}
+		if prior == nil {
+			return nil, errors.Errorf("cannot find the version of claim %v before transaction %v", claimId, txid)
+		}
+		t = prior
+	}
 }
 
 func lbryStreamCreate(name string, bid string, filePath string) error {
@@ -279,6 +604,9 @@ func lbryStreamCreate(name string, bid string, filePath string) error {
 		FilePath: filePath,
 		Blocking: true,
 	})
+	if err != nil {
+		return err
+	}
 
 	err = o.GetError()
 	if err != nil {
@@ -310,12 +638,10 @@ func rpcCall[Req any, Res any](method string, req Req) (Res, error) {
 
 	OutPrintf("rcp call: %v\n%v\n", method, string(rJson))
 
-	resp, err := http.Post(lbryrpcServer, "application/json", bytes.NewReader(rJson))
+	buf, err := rpcSend(method, rJson)
 	if err != nil {
-		return zero[Res](), errors.Wrap(err, "error durring http.POST to lbrynet rpc server")
+		return zero[Res](), err
 	}
-
-	buf := streamToByte(resp.Body)
 	OutPrintf("result:\n%v\n", string(buf))
 
 	var result rpcResult[Res]
@@ -0,0 +1,144 @@
+package glib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// The parts of a stream descriptor blob needed to decrypt the stream, see
+// StreamDescriptor in lbry-sdk
+type sdBlob struct {
+	Key   string        `json:"key"`
+	Blobs []*sdBlobInfo `json:"blobs"`
+}
+
+type sdBlobInfo struct {
+	BlobHash string `json:"blob_hash"`
+	BlobNum  int    `json:"blob_num"`
+	Iv       string `json:"iv"`
+
+	// Length of the encrypted blob, 0 for the terminator
+	Length int `json:"length"`
+}
+
+// Downloads the stream with the given sd_hash to fileName.  Unlike lbryGet
+// the stream doesn't have to be the current version of a claim, so earlier
+// versions of an updated claim can be read.  The daemon only saves whole
+// files through get, so it fetches the blobs and they are decrypted here.
+// Like lbryGet this expects the daemon to share the filesystem
+func lbryGetSdHash(sdHash string, fileName string) error {
+
+	type getArg struct {
+		BlobHash string `json:"blob_hash"`
+		Read     bool   `json:"read,omitempty"`
+	}
+
+	type settingsArg struct{}
+
+	type settingsOut struct {
+		DataDir string `json:"data_dir"`
+	}
+
+	// The descriptor is json so the daemon can return it as text
+	text, err := rpcCall[getArg, string]("blob_get", getArg{BlobHash: sdHash, Read: true})
+	if err != nil {
+		return err
+	}
+
+	var sd sdBlob
+	err = json.Unmarshal([]byte(text), &sd)
+	if err != nil {
+		return errors.Wrapf(err, "error reading stream descriptor %v", sdHash)
+	}
+
+	for _, b := range sd.Blobs {
+		if b.Length == 0 {
+			continue
+		}
+		_, err = rpcCall[getArg, string]("blob_get", getArg{BlobHash: b.BlobHash})
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := rpcCall[settingsArg, settingsOut]("settings_get", settingsArg{})
+	if err != nil {
+		return err
+	}
+
+	// Recordings carry the file contents since the daemon's blobs won't
+	// exist when replaying
+	if isReplaying() {
+		return rpcReplayFile(fileName)
+	}
+
+	b, err := decryptStream(&sd, func(hash string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(s.DataDir, "blobfiles", hash))
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error decrypting stream %v", sdHash)
+	}
+
+	err = os.WriteFile(fileName, b, 0666)
+	if err != nil {
+		return err
+	}
+
+	return rpcRecordFile(fileName)
+}
+
+// Decrypts the stream's blobs and joins them.  readBlob returns a blob's
+// encrypted contents.  Blobs are AES-CBC encrypted with the stream's key and
+// their own iv, padded as in PKCS #7
+func decryptStream(sd *sdBlob, readBlob func(hash string) ([]byte, error)) ([]byte, error) {
+
+	key, err := hex.DecodeString(sd.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := append([]*sdBlobInfo{}, sd.Blobs...)
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].BlobNum < blobs[j].BlobNum })
+
+	var result []byte
+	for _, b := range blobs {
+		if b.Length == 0 {
+			break
+		}
+
+		iv, err := hex.DecodeString(b.Iv)
+		if err != nil || len(iv) != aes.BlockSize {
+			return nil, errors.Errorf("invalid iv for blob %v", b.BlobNum)
+		}
+
+		data, err := readBlob(b.BlobHash)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+			return nil, errors.Errorf("blob %v is %v bytes, expected a multiple of %v", b.BlobNum, len(data), aes.BlockSize)
+		}
+
+		plain := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+		pad := int(plain[len(plain)-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, errors.Errorf("invalid padding in blob %v", b.BlobNum)
+		}
+		result = append(result, plain[:len(plain)-pad]...)
+	}
+
+	return result, nil
+}
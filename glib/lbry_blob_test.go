@@ -0,0 +1,55 @@
+package glib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecryptStream(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypt := func(iv []byte, plain []byte) []byte {
+		pad := aes.BlockSize - len(plain)%aes.BlockSize
+		padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		out := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+		return out
+	}
+
+	iv0 := bytes.Repeat([]byte{2}, 16)
+	iv1 := bytes.Repeat([]byte{3}, 16)
+	blobs := map[string][]byte{
+		"b0": encrypt(iv0, []byte(`{"gitlbry": 3, `)),
+		"b1": encrypt(iv1, []byte(`"authors": [0123456789abcdef]}`)),
+	}
+
+	// Listed out of order, with the terminator
+	sd := &sdBlob{
+		Key: hex.EncodeToString(key),
+		Blobs: []*sdBlobInfo{
+			{BlobHash: "b1", BlobNum: 1, Iv: hex.EncodeToString(iv1), Length: len(blobs["b1"])},
+			{BlobNum: 2, Iv: hex.EncodeToString(iv0)},
+			{BlobHash: "b0", BlobNum: 0, Iv: hex.EncodeToString(iv0), Length: len(blobs["b0"])},
+		},
+	}
+
+	got, err := decryptStream(sd, func(hash string) ([]byte, error) { return blobs[hash], nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"gitlbry": 3, "authors": [0123456789abcdef]}`; string(got) != want {
+		t.Errorf("decryptStream() = %q", got)
+	}
+
+	blobs["b1"] = blobs["b1"][1:]
+	if _, err := decryptStream(sd, func(hash string) ([]byte, error) { return blobs[hash], nil }); err == nil {
+		t.Errorf("decryptStream() should fail for a truncated blob")
+	}
+}
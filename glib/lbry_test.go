@@ -7,3 +7,22 @@ import (
 func Test_lbryMyChannelClaimId(t *testing.T) {
 
 }
+
+func TestIsWalletAddress(t *testing.T) {
+	tests := []struct {
+		x    string
+		want bool
+	}{
+		{x: "bHW58d37s1hBjj3wPBkn5zpCX3F8ZW3F3E", want: true},
+		{x: "rHW58d37s1hBjj3wPBkn5zpCX3F8ZW3F3E", want: true},
+		{x: "bHW58d37s1hBjj3wPBkn5zpCX3F8ZW3F3", want: false},
+		{x: "bHW58d37s1hBjj3wPBkn5zpCX3F8ZW3F30", want: false},
+		{x: "@bHW58d37s1hBjj3wPBkn5zpCX3F8ZW3F3", want: false},
+		{x: "gitlbry", want: false},
+	}
+	for _, tt := range tests {
+		if got := isWalletAddress(tt.x); got != tt.want {
+			t.Errorf("isWalletAddress(%v) = %v, want %v", tt.x, got, tt.want)
+		}
+	}
+}
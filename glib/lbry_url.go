@@ -2,137 +2,230 @@ package glib
 
 import (
 	"errors"
+	neturl "net/url"
 	"strings"
 	"unicode/utf8"
 
 	"golang.org/x/text/unicode/norm"
 )
 
-type lbryUrl string
+// A parsed lbry url e.g.
+// ```
+// lbry://@channel_name:23452/stream_name$2?key=value
+// ```
+type lbryUrl struct {
+
+	// Always "lbry"
+	scheme string
+
+	// Channel name without the leading @, empty if the url has no channel
+	channel         string
+	channelModifier lbryModifier
+
+	// Stream name, empty for a channel url
+	stream         string
+	streamModifier lbryModifier
+
+	// Query parameters in the order they appear in the url
+	query []lbryQueryParam
+}
+
+// An optional claim id, sequence or amount order modifier
+type lbryModifier struct {
+
+	// '#' for a claim id, '*' for a sequence, '$' for an amount order, or
+	// zero if there is no modifier.  Legacy ':' claim ids are stored as '#'
+	kind byte
 
+	// Lower case hex claim id or decimal number following kind
+	value string
+}
+
+type lbryQueryParam struct {
+	name string
 
-// Creates a new lbry url from the given string.  If 
-// not start with lbry:// it is assmed.
+	// Empty if the parameter has no value
+	value string
+}
+
+// Creates a new lbry url from the given string.  If
+// not start with lbry:// it is assmed.  Links to web frontends must be
+// converted with fromGatewayUrl first.
 func NewLbryUrl(x string) (lbryUrl, error) {
-	x = normalize(x);
+	x = normalize(x)
 
 	if !strings.HasPrefix(x, "lbry://") {
-		x = "lbry://" + x;
+		x = "lbry://" + x
 	}
 
-	y := lbryUrl(x);
-	if !y.IsValid() {
+	y, ok := parseUrl(x)
+	if !ok {
 		return zero[lbryUrl](), errors.New("invalid lbry url")
 	}
-	return y, nil;
+	return y, nil
 }
 
+// Converts a link to a web frontend for the lbry network into a lbry url.
+// ````
+// https://odysee.com/@chan:1/repo:2        lbry://@chan:1/repo:2
+// https://www.odysee.com/@chan%231/repo    lbry://@chan#1/repo
+// https://odysee.com/repo?r=abc            lbry://repo
+// ```
+// Returns x unchanged if it is not a http(s) link to one of the given hosts.
+// The link's query is dropped since it holds options for the frontend.
+func fromGatewayUrl(x string, hosts []string) string {
+
+	u, err := neturl.Parse(x)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return x
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	known := false
+	for _, h := range hosts {
+		if strings.ToLower(h) == host {
+			known = true
+		}
+	}
+	if !known {
+		return x
+	}
+
+	// Frontend pages e.g. /$/settings aren't claims
+	path := strings.TrimPrefix(u.Path, "/")
+	if path == "" || strings.HasPrefix(path, "$/") {
+		return x
+	}
+
+	// Modern claim ids in a link are parsed as a fragment
+	if u.Fragment != "" {
+		path = path + "#" + u.Fragment
+	}
+
+	return "lbry://" + path
+}
 
 func normalize(lbryName string) string {
 	return strings.ToLower(norm.NFD.String(lbryName))
 }
 
-
-// Returns true if the stream or channel has a 
+// Returns true if the stream or channel has a
 // ClaimId modifier, Sequence Modifier, or AmountOrder Modifier
 // ````
 // lbry://@channel_name:23452/stream_name   true
+// lbry://@channel_name#23452/stream_name   true
 // lbry://@channel_name/stream_name         false
 // lbry://@ChAnNeL_NaMe/stream_name$2       true
 // lbry://stream_name                       true
 // ```
 func HasModifiers(x string) bool {
-	return strings.IndexAny(x, ":*$") != -1;
+	return strings.IndexAny(x, "#:*$") != -1
 }
 
+func (m lbryModifier) String() string {
+	if m.kind == 0 {
+		return ""
+	}
+	return string(m.kind) + m.value
+}
 
-// For reference, this is regex that mostly validates urls
-//lbry:\/\/(@[^=&#:*$@%?/]+(?:[:*$]\d+))?/([^=&#:*$@%?/]+(?:[:*$]\d+))?
+// Formats the url in canonical form, claim ids are always written with the
+// modern '#' separator.  Parsing the result gives back an identical url
+func (url lbryUrl) String() string {
 
-// Returns the normalize channel name omitting any modifiers
-// ````
-// lbry://@channel_name:23452/stream_name   "channel_name", true
-// lbry://@channel_name/stream_name         "channel_name", true
-// lbry://@ChAnNeL_NaMe/stream_name         "channel_name", true
-// lbry://stream_name                       "", flase
-// ```
-func (url lbryUrl) ChannelName() (string, bool) {
-	
-	name := string(url);
+	var b strings.Builder
+	b.WriteString(url.scheme)
+	b.WriteString("://")
 
-	// Check for no channel
-	if !strings.HasPrefix(name, "lbry://@") {
-		return "", false;
+	if url.channel != "" {
+		b.WriteString("@")
+		b.WriteString(url.channel)
+		b.WriteString(url.channelModifier.String())
+		if url.stream != "" {
+			b.WriteString("/")
+		}
 	}
 
-	// Strip scheme and @
-	name = name[8:];
+	if url.stream != "" {
+		b.WriteString(url.stream)
+		b.WriteString(url.streamModifier.String())
+	}
 
-	// Modifier, Stream, And Query
-	end := strings.IndexAny(name, ":*$/?")
-	if end != -1 {
-		name = name[:end]
+	for i, param := range url.query {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(param.name)
+		if param.value != "" {
+			b.WriteString("=")
+			b.WriteString(param.value)
+		}
 	}
 
-	return name, len(name) > 0;
+	return b.String()
 }
 
+// Returns the url of just the channel, omitting the stream and query
+// ````
+// lbry://@channel_name:23452/stream_name   lbry://@channel_name#23452, true
+// lbry://stream_name                       "", false
+// ```
+func (url lbryUrl) ChannelUrl() (lbryUrl, bool) {
+	if url.channel == "" {
+		return zero[lbryUrl](), false
+	}
+	return lbryUrl{
+		scheme:          url.scheme,
+		channel:         url.channel,
+		channelModifier: url.channelModifier,
+	}, true
+}
 
+// Returns the url omitting the query
+func (url lbryUrl) WithoutQuery() lbryUrl {
+	url.query = nil
+	return url
+}
 
+// Returns the normalize channel name omitting any modifiers
+// ````
+// lbry://@channel_name:23452/stream_name   "channel_name", true
+// lbry://@channel_name/stream_name         "channel_name", true
+// lbry://@ChAnNeL_NaMe/stream_name         "channel_name", true
+// lbry://stream_name                       "", flase
+// ```
+func (url lbryUrl) ChannelName() (string, bool) {
+	return url.channel, url.channel != ""
+}
 
 // Returns the normalize channel name and modifiers
 // ````
-// lbry://@channel_name:23452/stream_name   "channel_name:23452", true
+// lbry://@channel_name:23452/stream_name   "channel_name#23452", true
+// lbry://@channel_name#23452/stream_name   "channel_name#23452", true
 // lbry://@channel_name$2/stream_name       "channel_name$2", true
 // lbry://@ChAnNeL_NaMe/stream_name         "channel_name", true
 // lbry://stream_name                       "", flase
 // ```
 func (url lbryUrl) ChannelWithModifiers() (string, bool) {
-	
-	name := string(url);
-
-	// Check for no channel
-	if !strings.HasPrefix(name, "lbry://@") {
-		return "", false;
-	}
-
-	// Strip scheme and @
-	name = name[8:];
-
-	// Modifier, Stream, And Query
-	end := strings.IndexAny(name, "/?")
-	if end != -1 {
-		name = name[:end]
+	if url.channel == "" {
+		return "", false
 	}
-
-	return name, len(name) > 0;
+	return url.channel + url.channelModifier.String(), true
 }
 
 // Returns the normalized stream name and modifiers
+// ````
+// lbry://@channel_name:23452/stream_name:2342   "stream_name#2342", true
+// lbry://StReAM_NaMe                            "stream_name", true
+// lbry://@channel_name                          "", flase
+// ```
 func (url lbryUrl) StreamWithModifiers() (string, bool) {
-	
-	x := string(url);
-
-	// Remove scheme
-	x = x[7:];
-
-	// Remove channel
-	if strings.HasPrefix(x, "@") {
-		idx := strings.Index(x, "/");
-		if idx == -1 {
-			// url is for a channel
-			return "", false
-		}
-
-		x = x[idx:]
-	}
-
-	// Remove Query
-	idx := strings.Index(x, "?");
-	if idx != -1 {
-		x = x[:idx];
+	if url.stream == "" {
+		return "", false
 	}
-	return x, true;
+	return url.stream + url.streamModifier.String(), true
 }
 
 // Returns the normalize channel name omitting any modifiers
@@ -143,260 +236,229 @@ func (url lbryUrl) StreamWithModifiers() (string, bool) {
 // lbry://@channel_name                     "", flase
 // ```
 func (url lbryUrl) StreamName() (string, bool) {
+	return url.stream, url.stream != ""
+}
 
-	name := string(url);
-
-	// Strip Scheme
-	name = name[7:]
-
-	// Strip Channel
-	if strings.HasPrefix(name, "@") {
-		end := strings.IndexAny(name, "/")
-		if end == -1 {
-			// No stream, this is a channel url
-			return "", false
+// Returns the value of the query parameter with the given name
+func (url lbryUrl) QueryValue(name string) (string, bool) {
+	for _, param := range url.query {
+		if param.name == name {
+			return param.value, true
 		}
-		name = name[end+1:]
 	}
-
-	// Strip Modifier and query
-	end := strings.IndexAny(name, ":*$?")
-	if end != -1 {
-		name = name[:end]
-	}
-
-	return name, len(name) > 0;
-}
-
-func (url lbryUrl) IsValid() bool {
-	x, ok := parseUrl(string(url));
-	return ok && len(x) == 0;
+	return "", false
 }
 
-func  IsUrlValid(x string) bool {
-	x = normalize(x);
-	x, ok := parseUrl(x);
-	return ok && len(x) == 0;
+func IsUrlValid(x string) bool {
+	x = normalize(x)
+	_, ok := parseUrl(x)
+	return ok
 }
 
+// For reference, this is regex that mostly validates urls
+//lbry:\/\/(@[^=&#:*$@%?/]+(?:[#:*$]\d+))?/([^=&#:*$@%?/]+(?:[#:*$]\d+))?
 
+// Parses a complete url, returns false if x is not a valid url or has
+// trailing characters
+func parseUrl(x string) (lbryUrl, bool) {
 
-func parseUrl(x string) (string, bool) {
-
+	var url lbryUrl
 	var ok bool
 
-	x, ok = parseScheme(x);
+	x, ok = parseScheme(x, &url)
 	if !ok {
-		return "", false;
+		return zero[lbryUrl](), false
 	}
 
-	x, ok = parsePath(x);
+	x, ok = parsePath(x, &url)
 	if !ok {
-		return "", false;
+		return zero[lbryUrl](), false
 	}
 
-	x, ok = parseOptionalQuery(x);
+	x, ok = parseOptionalQuery(x, &url)
 	if !ok {
-		return "", false
+		return zero[lbryUrl](), false
 	}
 
-	return x, ok;
-
+	return url, len(x) == 0
 }
 
-func parseScheme(x string) (string, bool) {
+func parseScheme(x string, url *lbryUrl) (string, bool) {
 	if !strings.HasPrefix(x, "lbry://") {
 		return "", false
 	}
+	url.scheme = "lbry"
 	return x[7:], true
 }
 
-func parsePath(x string) (string, bool) {
+func parsePath(x string, url *lbryUrl) (string, bool) {
 	var ok bool
 
 	if strings.HasPrefix(x, "@") {
 
 		// Channel Claim
 		x = x[1:]
-		x, ok = parseClaimAndModifier(x);
+		x, url.channel, url.channelModifier, ok = parseClaimAndModifier(x)
 		if !ok {
-			return "", false;
+			return "", false
 		}
 
 		// Optional stream claim
 		if strings.HasPrefix(x, "/") {
 			x = x[1:]
-			return parseClaimAndModifier(x);
-		} 
-			
-		return x, ok;
+			x, url.stream, url.streamModifier, ok = parseClaimAndModifier(x)
+		}
+
+		return x, ok
 
 	} else {
-		return parseClaimAndModifier(x);
+		x, url.stream, url.streamModifier, ok = parseClaimAndModifier(x)
+		return x, ok
 	}
 }
 
-func parseClaimAndModifier(x string) (string, bool) {
+func parseClaimAndModifier(x string) (string, string, lbryModifier, bool) {
 
 	var ok bool
+	var name string
+	var modifier lbryModifier
 
-
-	x, ok = parseName(x)
+	x, name, ok = parseName(x)
 	if !ok {
-		return "", false
+		return "", "", modifier, false
 	}
 
-	return parseOptionalModifier(x) 
+	x, modifier, ok = parseOptionalModifier(x)
+	return x, name, modifier, ok
 
 }
 
-func parseOptionalModifier(x string) (string, bool) {
-	if strings.HasPrefix(x, ":") {
-		x = x[1:]
-		return parseHex(x);
+func parseOptionalModifier(x string) (string, lbryModifier, bool) {
+	var ok bool
+	var modifier lbryModifier
+
+	if strings.HasPrefix(x, "#") || strings.HasPrefix(x, ":") {
+		modifier.kind = '#'
+		x, modifier.value, ok = parseHex(x[1:])
+		modifier.value = strings.ToLower(modifier.value)
+		return x, modifier, ok
 	} else if strings.HasPrefix(x, "*") || strings.HasPrefix(x, "$") {
-		x = x[1:]
-		return parsePositiveNumber(x)
+		modifier.kind = x[0]
+		x, modifier.value, ok = parsePositiveNumber(x[1:])
+		return x, modifier, ok
 	}
-	return x, true;
+	return x, modifier, true
 }
 
-func parsePositiveNumber(x string) (string, bool) {
-	ok := false;
+// Consumes the longest prefix of x where every rune satisfies accept.  Returns
+// the remainder, the prefix, and false if the prefix is empty or x is not
+// valid utf8
+func parseWhile(x string, accept func(rune) bool) (string, string, bool) {
+	start := x
 	for {
 
-		if (len(x) == 0) {
-			break;
+		if len(x) == 0 {
+			break
 		}
 
 		r, size := utf8.DecodeRuneInString(x)
 		if r == utf8.RuneError {
-			return "", false
+			return "", "", false
 		}
 
-		if !isDigitChar(r) {
-			break;
+		if !accept(r) {
+			break
 		}
 
-		ok = true;
 		x = x[size:]
 
 	}
 
-	return x, ok
+	token := start[:len(start)-len(x)]
+	return x, token, len(token) > 0
 }
 
-func parseHex(x string) (string, bool) {
-	ok := false;
-	for {
-
-		if (len(x) == 0) {
-			break;
-		}
-
-		r, size := utf8.DecodeRuneInString(x)
-		if r == utf8.RuneError {
-			return "", false
-		}
-
-		if !isHexChar(r) {
-			break;
-		}
-
-		ok = true;
-		x = x[size:]
-
-	}
-
-	return x, ok
+func parsePositiveNumber(x string) (string, string, bool) {
+	return parseWhile(x, isDigitChar)
 }
 
-func parseName(x string) (string, bool) {
-
-	ok := false;
-	for {
-
-		if (len(x) == 0) {
-			break;
-		}
-
-		r, size := utf8.DecodeRuneInString(x)
-		if r == utf8.RuneError {
-			return "", false
-		}
-
-		if !isNameChar(r) {
-			break;
-		}
-
-		ok = true;
-		x = x[size:]
-
-	}
-
-	return x, ok
+func parseHex(x string) (string, string, bool) {
+	return parseWhile(x, isHexChar)
 }
 
+func parseName(x string) (string, string, bool) {
+	return parseWhile(x, isNameChar)
+}
 
 func isNameChar(x rune) bool {
-	
-	if strings.ContainsAny(string(x), "=&#:*$@%?/") {
-		return false;
+
+	if strings.ContainsRune("=&#:*$@%?/", x) {
+		return false
 	}
-	
+
 	return x == 0x9 ||
-	 x== 0xA ||
-	 x == 0xD ||
-	 (x >= 0x20 && x <= 0xD7FF) || 
-	 (x >= 0xE000 && x <= 0xFFFD) ||
-	 (x >= 0x10000 && x <= 0x10FFFF)
+		x == 0xA ||
+		x == 0xD ||
+		(x >= 0x20 && x <= 0xD7FF) ||
+		(x >= 0xE000 && x <= 0xFFFD) ||
+		(x >= 0x10000 && x <= 0x10FFFF)
 
 }
 
-func isHexChar(x rune) bool {
-	return (x >= 0x61 && x <= 0x66) || (x >= 0x30 && x <= 0x39)
+// Values may hold urls e.g. channel=@bot#ab so only the query separators
+// are excluded
+func isQueryValueChar(x rune) bool {
+	return isNameChar(x) || strings.ContainsRune("=#:*$@%/", x)
 }
 
+func isHexChar(x rune) bool {
+	return (x >= 0x61 && x <= 0x66) || (x >= 0x41 && x <= 0x46) || (x >= 0x30 && x <= 0x39)
+}
 
 func isDigitChar(x rune) bool {
 	return x >= 0x30 && x <= 0x39
 }
 
-func parseOptionalQuery(x string) (string, bool) {
-	var ok bool;
+func parseOptionalQuery(x string, url *lbryUrl) (string, bool) {
+	var ok bool
+	var param lbryQueryParam
+
 	if !strings.HasPrefix(x, "?") {
 		return x, true
 	}
 	x = x[1:]
 
-	x, ok = parseQueryParameter(x);
+	x, param, ok = parseQueryParameter(x)
 	if !ok {
 		return "", false
 	}
+	url.query = append(url.query, param)
 
 	for strings.HasPrefix(x, "&") {
-		x, ok = parseQueryParameter(x);
+		x, param, ok = parseQueryParameter(x[1:])
 		if !ok {
 			return "", false
 		}
+		url.query = append(url.query, param)
 	}
 
-	return x, true;
+	return x, true
 
 }
 
-func parseQueryParameter(x string) (string, bool) {
-	var ok bool;
+func parseQueryParameter(x string) (string, lbryQueryParam, bool) {
+	var ok bool
+	var param lbryQueryParam
 
-	x, ok = parseName(x)
+	x, param.name, ok = parseName(x)
 	if !ok {
-		return "", false
+		return "", param, false
 	}
 
 	if strings.HasPrefix(x, "=") {
-		return parseName(x)
+		x, param.value, ok = parseWhile(x[1:], isQueryValueChar)
 	}
 
-	return x, ok;
+	return x, param, ok
 
-}
\ No newline at end of file
+}
@@ -1,6 +1,7 @@
 package glib
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -39,7 +40,13 @@ func TestNewLbryUrl(t *testing.T) {
 		{ wantOk: true, url: "lbry://@channel:EF234/stream*234"},
 		{ wantOk: true, url: "lbry://@channel:EF234/stream"},
 
+		{ wantOk: true, url: "lbry://@channel#ef234/stream#ab"},
+		{ wantOk: true, url: "lbry://@channel#EF234/stream:Ab"},
+		{ wantOk: true, url: "lbry://stream#ab"},
+
 		{ wantOk: false, url: `lbry:\\@channel`},
+		{ wantOk: false, url: "lbry://stream#"},
+		{ wantOk: false, url: "lbry://stream#xyz"},
 		{ wantOk: false, url: "@channel"},
 
 	}
@@ -52,3 +59,143 @@ func TestNewLbryUrl(t *testing.T) {
 		})
 	}
 }
+
+func TestParseUrl(t *testing.T) {
+	tests := []struct {
+		url        string
+		canonical  string
+		channel    string
+		chModifier string
+		stream     string
+		stModifier string
+		query      []lbryQueryParam
+	}{
+		{url: "lbry://stream", stream: "stream"},
+		{url: "lbry://stream$1", stream: "stream", stModifier: "$1"},
+		{url: "lbry://stream:ef234", canonical: "lbry://stream#ef234", stream: "stream", stModifier: "#ef234"},
+		{url: "lbry://stream#ef234", stream: "stream", stModifier: "#ef234"},
+		{url: "lbry://stream#EF234", canonical: "lbry://stream#ef234", stream: "stream", stModifier: "#ef234"},
+		{url: "lbry://stream*234", stream: "stream", stModifier: "*234"},
+		{url: "lbry://@channel", channel: "channel"},
+		{url: "lbry://@channel:ef234", canonical: "lbry://@channel#ef234", channel: "channel", chModifier: "#ef234"},
+		{url: "lbry://@channel/stream", channel: "channel", stream: "stream"},
+		{url: "lbry://@channel:ef234/stream$1", canonical: "lbry://@channel#ef234/stream$1", channel: "channel", chModifier: "#ef234", stream: "stream", stModifier: "$1"},
+		{url: "lbry://@channel#e6/stream#ab", channel: "channel", chModifier: "#e6", stream: "stream", stModifier: "#ab"},
+		{url: "lbry://stream?a", stream: "stream", query: []lbryQueryParam{{name: "a"}}},
+		{url: "lbry://stream?a=1&b&c=3", stream: "stream", query: []lbryQueryParam{{name: "a", value: "1"}, {name: "b"}, {name: "c", value: "3"}}},
+		{url: "lbry://@channel/stream?a=1", channel: "channel", stream: "stream", query: []lbryQueryParam{{name: "a", value: "1"}}},
+		{url: "lbry://stream?channel=@bot#ab&bid=0.01", stream: "stream", query: []lbryQueryParam{{name: "channel", value: "@bot#ab"}, {name: "bid", value: "0.01"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, ok := parseUrl(tt.url)
+			if !ok {
+				t.Fatalf("parseUrl(%v) failed", tt.url)
+			}
+			if u.channel != tt.channel || u.channelModifier.String() != tt.chModifier {
+				t.Errorf("channel = %q %q, want %q %q", u.channel, u.channelModifier, tt.channel, tt.chModifier)
+			}
+			if u.stream != tt.stream || u.streamModifier.String() != tt.stModifier {
+				t.Errorf("stream = %q %q, want %q %q", u.stream, u.streamModifier, tt.stream, tt.stModifier)
+			}
+			if !reflect.DeepEqual(u.query, tt.query) {
+				t.Errorf("query = %v, want %v", u.query, tt.query)
+			}
+			want := tt.canonical
+			if want == "" {
+				want = tt.url
+			}
+			if u.String() != want {
+				t.Errorf("String() = %v, want %v", u.String(), want)
+			}
+		})
+	}
+}
+
+func TestLbryUrlHelpers(t *testing.T) {
+	u, err := NewLbryUrl("@ChAnNeL:ef234/StReAm$2?a=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, got string, gotOk bool, want string, wantOk bool) {
+		if got != want || gotOk != wantOk {
+			t.Errorf("%v = %q, %v want %q, %v", name, got, gotOk, want, wantOk)
+		}
+	}
+
+	name, ok := u.ChannelName()
+	check("ChannelName", name, ok, "channel", true)
+	name, ok = u.ChannelWithModifiers()
+	check("ChannelWithModifiers", name, ok, "channel#ef234", true)
+	name, ok = u.StreamName()
+	check("StreamName", name, ok, "stream", true)
+	name, ok = u.StreamWithModifiers()
+	check("StreamWithModifiers", name, ok, "stream$2", true)
+	ch, ok := u.ChannelUrl()
+	check("ChannelUrl", ch.String(), ok, "lbry://@channel#ef234", true)
+	check("WithoutQuery", u.WithoutQuery().String(), true, "lbry://@channel#ef234/stream$2", true)
+
+	u, err = NewLbryUrl("stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, ok = u.ChannelName()
+	check("ChannelName", name, ok, "", false)
+	name, ok = u.StreamWithModifiers()
+	check("StreamWithModifiers", name, ok, "stream", true)
+}
+
+func FuzzParseUrl(f *testing.F) {
+	seeds := []string{
+		"lbry://stream",
+		"lbry://@channel:ef234/stream$1",
+		"lbry://@channel#EF234/stream#ab",
+		"lbry://@channel*3",
+		"lbry://stream?a=1&b",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, x string) {
+		u, ok := parseUrl(x)
+		if !ok {
+			return
+		}
+		u2, ok := parseUrl(u.String())
+		if !ok {
+			t.Fatalf("parseUrl(%q) failed for String() of %q", u.String(), x)
+		}
+		if !reflect.DeepEqual(u, u2) {
+			t.Errorf("round trip of %q gave %+v, want %+v", x, u2, u)
+		}
+	})
+}
+
+func TestFromGatewayUrl(t *testing.T) {
+	hosts := []string{"odysee.com", "lbry.tv"}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://odysee.com/@chan:1/repo:2", want: "lbry://@chan:1/repo:2"},
+		{url: "http://odysee.com/repo", want: "lbry://repo"},
+		{url: "https://www.odysee.com/@chan%231/repo%232", want: "lbry://@chan#1/repo#2"},
+		{url: "https://Odysee.com/@chan#1/repo#2", want: "lbry://@chan#1/repo#2"},
+		{url: "https://lbry.tv/@chan:1/repo:2?r=abc", want: "lbry://@chan:1/repo:2"},
+
+		{url: "https://example.com/@chan:1/repo:2", want: "https://example.com/@chan:1/repo:2"},
+		{url: "https://odysee.com/$/settings", want: "https://odysee.com/$/settings"},
+		{url: "https://odysee.com/", want: "https://odysee.com/"},
+		{url: "lbry://@chan:1/repo:2", want: "lbry://@chan:1/repo:2"},
+		{url: "@chan/repo", want: "@chan/repo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got := fromGatewayUrl(tt.url, hosts)
+			if got != tt.want {
+				t.Errorf("fromGatewayUrl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
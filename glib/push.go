@@ -3,8 +3,11 @@ package glib
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os/exec"
 	"regexp"
+	"strings"
+	"time"
 )
 
 type PushData struct {
@@ -43,17 +46,61 @@ func parsePushLine(raw string) (PushData, error) {
 
 func (s Startup) push(firstLine string) error {
 
+	// Parse all grouped pushes from standard in
+	OutPrintf("reading push commands")
+	args, err := s.readPushCommnds(firstLine)
+	if err != nil {
+		return err
+	}
+
+	// A snapshot can't move forward
+	if s.rh.options.snapshot >= 0 {
+		err = errors.New("cannot push to a remote pinned with snapshot=, remove it from the remote url")
+		writePushResultError(args, err.Error())
+		return err
+	}
+
+	// Patches published while frozen would be ignored
+	if s.settings.isFrozen() {
+		err = fmt.Errorf("the repo is not accepting pushes, %v", s.settings.freezeStatus())
+		writePushResultError(args, err.Error())
+		return err
+	}
+
+	// A patch pushed now would compete with the one being approved
+	if p := s.sync.Pending; p != nil {
+		err = fmt.Errorf("patch %v has %v of %v approvals, push again once it is approved or deleted, or after block %v.  %v", s.sync.DownloadIndex, p.Approvals, p.Required, p.Deadline, s.settings.pendingHelp(p))
+		writePushResultError(args, err.Error())
+		return err
+	}
+
 	// Get Channel to push with
 	cfg := loadConfig()
-	if cfg.Default.PushAs == nil {
-		return errors.New("before pushing need to set author.  See gitlbry me <lbry_channel>\n")
+	pushAs, err := s.rh.options.pushAs(cfg)
+	if err != nil {
+		writePushResultError(args, err.Error())
+		return err
+	}
+	authrorId := pushAs.ClaimId
+
+	// Refuse changes that sync would ignore because of the ref rules
+	OutPrintf("checking ref rules")
+	rejected := s.checkPushRules(authrorId, args)
+	if len(rejected) > 0 {
+		writePushResultRejected(args, rejected)
+		return errors.New("push breaks the repo's ref rules")
 	}
-	authrorId := cfg.Default.PushAs.ClaimId
 
-	// Parse all grouped pushes from standard in
-	OutPrintf("reading push commands")
-	args, err := s.readPushCommnds(firstLine)
+	// Don't knowingly publish a patch that will lose to another one, before
+	// the mirror's refs move
+	OutPrintf("checking for competing patches")
+	err = s.checkForConflicts()
+	if err == PatchConflictErr {
+		writePushResultError(args, "fetch first")
+		return err
+	}
 	if err != nil {
+		writePushResultError(args, err.Error())
 		return err
 	}
 
@@ -61,15 +108,36 @@ func (s Startup) push(firstLine string) error {
 	OutPrintf("attempting to push locally to ./.gitlbry/<repohash>/")
 	err = s.pushAllLocal(args)
 	if err != nil {
-		writePushResultError(args)
+		writePushResultError(args, err.Error())
 		return err
 	}
 
 	// Pack Objects
 	OutPrintf("packing objects")
-	err = s.createBundle(authrorId, args)
+	patch, err := s.createBundle(authrorId, args)
 	if err != nil {
-		writePushResultError(args)
+		writePushResultError(args, err.Error())
+		return err
+	}
+
+	// Wait until the patch is confirmed and canonical
+	confirmations := s.rh.options.confirmationsOr(cfg, s.rh.url.WithoutQuery().String())
+	OutPrintf("waiting for %v confirmations", confirmations)
+	err = s.waitForPatch(patch, confirmations)
+	if err == PatchTimeoutErr {
+		// The patch is published, pushing again would publish a duplicate
+		OutPrintf("warning: patch %v at %v hasn't confirmed yet, fetch later to check that it was applied", s.sync.DownloadIndex, patch.PermanentUrl)
+		err = nil
+	}
+	if err == PatchLostErr {
+		// The mirror only holds canonical patches
+		resetErr := s.resetLocalRefs(args)
+		if resetErr != nil {
+			OutPrintf("error resetting ./.gitlbry/<repohash>/ to the last fetch: %v", resetErr)
+		}
+	}
+	if err != nil {
+		writePushResultError(args, err.Error())
 		return err
 	}
 
@@ -129,10 +197,104 @@ func (s Startup) pushAllLocal(args []PushData) error {
 
 }
 
-func (s Startup) createBundle(authorId string, pd []PushData) error {
+// Checks each pushed ref against the settings' ref rules.  The height the
+// patch confirms at isn't known yet, so the rules are those in effect once
+// every settings change has confirmed.  Returns why each rejected ref was
+// rejected by its dst
+func (s Startup) checkPushRules(authorId string, args []PushData) map[string]string {
+
+	rejected := map[string]string{}
+	if len(s.settings.Refs) == 0 {
+		return rejected
+	}
+
+	current := map[string]string{}
+	for _, r := range s.refs {
+		current[r.name] = r.ref.toHexString()
+	}
+
+	isAncestor := func(old string, new string) bool {
+		return exec.Command("git", "merge-base", "--is-ancestor", old, new).Run() == nil
+	}
+
+	for _, arg := range args {
+
+		// An empty src deletes the ref
+		new := ""
+		if arg.src != "" {
+			out, err := exec.Command("git", "rev-parse", "--verify", arg.src).Output()
+			if err != nil {
+				rejected[arg.dst] = fmt.Sprintf("cannot resolve %v", arg.src)
+				continue
+			}
+			new = strings.TrimSpace(string(out))
+		}
+
+		u := refUpdate{
+			name: arg.dst,
+			old:  current[arg.dst],
+			new:  new,
+		}
+		err := s.settings.checkRefUpdate(authorId, math.MaxInt64, u, isAncestor)
+		if err != nil {
+			rejected[arg.dst] = err.Error()
+		}
+	}
+
+	return rejected
+}
+
+// The name and description of the patch that the next push will publish
+func (s Startup) nextPatch() (string, string) {
+	name := s.settings.patchName(s.rh, s.sync.DownloadIndex)
+	description := s.sync.DownloadPriorHash
+	return name, description
+}
+
+var PatchConflictErr error = errors.New("another patch was published since the last fetch, fetch and push again")
+
+// Returns PatchConflictErr if a candidate for the next patch already exists,
+// either confirmed on the lbry network or still unconfirmed.  Unconfirmed
+// patches from other wallets are only visible if the hub indexes the
+// mem-pool.
+func (s Startup) checkForConflicts() error {
+
+	name, description := s.nextPatch()
+
+	bundle, err := findBundle(name, s.sync.DownloadIndex, description, s.settings)
+	if err != nil && err != BundleNotFoundErr {
+		return err
+	}
+	if err == nil {
+		OutPrintf("found competing patch %v", bundle.PermanentUrl)
+		return PatchConflictErr
+	}
+
+	pending, err := lbryMyUnconfirmedStreams(name)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		OutPrintf("found unconfirmed patch %v", pending[0].PermanentUrl)
+		return PatchConflictErr
+	}
+
+	others, err := findUnconfirmedBundles(name, s.sync.DownloadIndex, description, s.settings)
+	if err != nil {
+		return err
+	}
+	if len(others) > 0 {
+		OutPrintf("found unconfirmed patch %v from %v", others[0].PermanentUrl, others[0].ChannelId)
+		return PatchConflictErr
+	}
+
+	return nil
+}
+
+func (s Startup) createBundle(authorId string, pd []PushData) (*sdkOutput, error) {
 
 	// Construct command line args
-	filePath := s.rh.outBundlePath(s.sync.Index)
+	filePath := s.rh.outBundlePath(s.sync.DownloadIndex)
 	cmdArgs := []string{
 		"bundle",
 		"create",
@@ -154,16 +316,90 @@ func (s Startup) createBundle(authorId string, pd []PushData) error {
 	// Have git create the bundle
 	out, err := exec.Command("git", cmdArgs...).CombinedOutput()
 	OutPrintf("git %v %v", cmdArgs, string(out))
+	if err != nil {
+		return nil, err
+	}
 
 	// Upload to lbry
-	name := fmt.Sprintf("%v-%v", s.rh.name, s.sync.DownloadIndex)
-	description := s.sync.DownloadPriorHash
-	err = lbryStreamCreateForBundle(name, authorId, description, "0.001", filePath)
-	if err != nil {
-		return err
+	name, description := s.nextPatch()
+	return lbryStreamCreateForBundle(name, authorId, description, s.rh.options.bid, filePath)
+}
+
+// How often to poll the lbry network while waiting for a patch to confirm
+const confirmPollInterval = 15 * time.Second
+
+// How long to wait for a patch to confirm before giving up
+const confirmTimeout = 30 * time.Minute
+
+var PatchLostErr error = errors.New("the patch lost to a competing patch, fetch and push again")
+
+var PatchTimeoutErr error = errors.New("timed out waiting for the patch to confirm")
+
+// Blocks until the published patch is the canonical candidate for its index
+// and has at least the given number of confirmations.  Returns PatchLostErr
+// if a competing patch became canonical instead, or PatchTimeoutErr if the
+// patch doesn't confirm within confirmTimeout.
+func (s Startup) waitForPatch(patch *sdkOutput, confirmations int) error {
+
+	if confirmations <= 0 {
+		return nil
+	}
+
+	name, description := s.nextPatch()
+	start := time.Now()
+	for {
+
+		bundle, err := findBundle(name, s.sync.DownloadIndex, description, s.settings)
+		if err != nil && err != BundleNotFoundErr {
+			return err
+		}
+
+		if err == nil {
+
+			// Someone else's patch is older than ours
+			if bundle.ClaimId != patch.ClaimId {
+				OutPrintf("patch %v lost to competing patch %v", s.sync.DownloadIndex, bundle.PermanentUrl)
+				return PatchLostErr
+			}
+
+			OutPrintf("patch %v has %v of %v confirmations", s.sync.DownloadIndex, bundle.Confirmations, confirmations)
+			if bundle.Confirmations >= confirmations {
+				return nil
+			}
+		}
+
+		if time.Since(start) > confirmTimeout {
+			return PatchTimeoutErr
+		}
+
+		time.Sleep(confirmPollInterval)
+	}
+}
+
+// Points the refs pushed to the local mirror back at the commits they had
+// after the last fetch, deleting those that didn't exist then
+func (s Startup) resetLocalRefs(args []PushData) error {
+
+	synced := map[string]NamedRef{}
+	for _, r := range s.refs {
+		synced[r.name] = r
+	}
+
+	var heads []NamedRef
+	for _, arg := range args {
+		if r, ok := synced[arg.dst]; ok {
+			heads = append(heads, r)
+			continue
+		}
+		cmd := exec.Command("git", "update-ref", "-d", arg.dst)
+		cmd.Dir = s.rh.gitRemoteClonePath()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git update-ref -d %v %s: %w", arg.dst, out, err)
+		}
 	}
 
-	return err
+	return s.rh.updateRefs(heads)
 }
 
 func writePushResultOk(x []PushData) {
@@ -174,10 +410,26 @@ func writePushResultOk(x []PushData) {
 	Printf("\n")
 }
 
-func writePushResultError(x []PushData) {
+// Writes why each rejected ref was rejected.  A push is a single patch so the
+// other refs fail with them
+func writePushResultRejected(x []PushData, rejected map[string]string) {
+	OutPrintf("Writing Push Results Rejected len: %v", len(x))
+	for _, a := range x {
+		why, ok := rejected[a.dst]
+		if !ok {
+			why = "atomic push failed"
+		}
+		why = strings.ReplaceAll(why, "\n", " ")
+		Printf("error %v %v\n", a.dst, why)
+	}
+	Printf("\n")
+}
+
+func writePushResultError(x []PushData, why string) {
 	OutPrintf("Writing Push Results Error len: %v", len(x))
+	why = strings.ReplaceAll(why, "\n", " ")
 	for _, a := range x {
-		Printf("error %v\n", a.dst)
+		Printf("error %v %v\n", a.dst, why)
 	}
 	Printf("\n")
 }
@@ -0,0 +1,218 @@
+package glib
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pseudo-deletes the claim so that sync ignores it, see glSettings.Deleted
+func (s *glSettings) delete(claimId string) {
+	if !s.isDeleted(claimId) {
+		s.Deleted = append(s.Deleted, claimId)
+	}
+}
+
+func (s *glSettings) undelete(claimId string) {
+	var kept []string
+	for _, deleted := range s.Deleted {
+		if deleted != claimId {
+			kept = append(kept, deleted)
+		}
+	}
+	s.Deleted = kept
+}
+
+func isClaimId(x string) bool {
+	if len(x) != 40 {
+		return false
+	}
+	for _, r := range x {
+		if !isHexChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the claim id of the patch given either as a claim id or as a patch
+// index looked up in the cache
+func (c *claimCache) patchClaimId(x string) (string, error) {
+
+	if isClaimId(x) {
+		return strings.ToLower(x), nil
+	}
+
+	index, err := strconv.Atoi(x)
+	if err != nil || index < 0 {
+		return "", errors.Errorf("invalid patch %v, expected a patch index or claim id", x)
+	}
+	if index >= len(c.Patches) || c.Patches[index] == nil {
+		return "", errors.Errorf("patch %v is not in the local clone, fetch first or use its claim id", index)
+	}
+	return c.Patches[index].ClaimId, nil
+}
+
+// Fills in missing cache entries for applied patches, e.g. for clones synced
+// before the cache existed, by finding the claim whose file matches each
+// local bundle.  Deleted and frozen patches are searched too, since those are
+// the ones that need to be found.  Stops at the first patch no claim matches
+// and remembers its bundle in cache.Unmatched, so later fetches don't search
+// for it or the patches built on it again
+func (rh RepoName) fillPatchCache(settings *glSettings, applied int, cache *claimCache) error {
+
+	all := *settings
+	all.Deleted = nil
+	all.FrozenAt = 0
+	all.Freezes = nil
+
+	for i := 0; i < applied; i += 1 {
+		if i < len(cache.Patches) && cache.Patches[i] != nil {
+			continue
+		}
+
+		sha, err := fileSha1(rh.inBundlePath(i))
+		if err != nil {
+			return err
+		}
+		if cache.Unmatched[sha] {
+			break
+		}
+		prior := ""
+		if i > 0 {
+			prior, err = fileSha1(rh.inBundlePath(i - 1))
+			if err != nil {
+				return err
+			}
+		}
+
+		candidates, err := findBundles(settings.patchName(rh, i), i, prior, &all)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, candidate := range candidates {
+			path, err := newTempPath()
+			if err != nil {
+				return err
+			}
+			err = lbryGet(candidate.PermanentUrl, path)
+			if err != nil {
+				os.Remove(path)
+				return err
+			}
+			candidateSha, err := fileSha1(path)
+			os.Remove(path)
+			if err != nil {
+				return err
+			}
+			if candidateSha == sha {
+				cache.fillPatch(i, candidate.cached())
+				found = true
+				break
+			}
+		}
+		if !found {
+			OutPrintf("no claim found for applied patch %v", i)
+			if cache.Unmatched == nil {
+				cache.Unmatched = map[string]bool{}
+			}
+			cache.Unmatched[sha] = true
+			break
+		}
+	}
+	return nil
+}
+
+// Patches applied while unconfirmed are cached without a height.  Looks up
+// the heights they confirmed at so that they can be checked against freezes
+func (c *claimCache) refreshPatchHeights(settings *glSettings, applied int) {
+	if !settings.isFrozen() && len(settings.Freezes) == 0 {
+		return
+	}
+	for i, patch := range c.Patches {
+		if i >= applied {
+			break
+		}
+		if patch == nil || patch.Height > 0 {
+			continue
+		}
+		claim, err := lbryResolve(patch.PermanentUrl)
+		if err != nil {
+			OutPrintf("cannot resolve applied patch %v: %v", i, err)
+			continue
+		}
+		patch.Height = claim.Height
+	}
+}
+
+// Returns the index of the first applied patch that is now deleted or was
+// published while the repo was frozen, or -1 if there isn't one.  A patch
+// can be applied while it and a freeze are both unconfirmed and then confirm
+// after the freeze
+func (c *claimCache) firstRejectedPatch(settings *glSettings, applied int) int {
+	for i, patch := range c.Patches {
+		if i >= applied {
+			break
+		}
+		if patch != nil && (settings.isDeleted(patch.ClaimId) || settings.isFrozenAt(int64(patch.Height))) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Rolls the local clone back to how it was before the patch with the given
+// index was applied, so that sync can find the canonical patches from there
+// again.  Refs are reset by re-applying the earlier bundles, and objects only
+// reachable from later patches are pruned.
+func (rh RepoName) rebuildFrom(index int, sync *Sync, cache *claimCache) error {
+
+	OutPrintf("rebuilding local clone from patch %v", index)
+
+	refs, err := rh.loadRefs()
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		cmd := exec.Command("git", "update-ref", "-d", ref.name)
+		cmd.Dir = rh.gitRemoteClonePath()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "git update-ref -d %v %s", ref.name, out)
+		}
+	}
+
+	prior := ""
+	if index > 0 {
+		prior, err = fileSha1(rh.inBundlePath(index - 1))
+		if err != nil {
+			return err
+		}
+	}
+
+	sync.Index = 0
+	sync.DownloadIndex = index
+	sync.DownloadPriorHash = prior
+	sync.Pending = nil
+	cache.Patches = cache.Patches[:index]
+
+	err = rh.applyBundles(sync)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", "gc", "--prune=now", "--quiet")
+	cmd.Dir = rh.gitRemoteClonePath()
+	out, err := cmd.CombinedOutput()
+	OutPrintf("git gc %s", out)
+	if err != nil {
+		return err
+	}
+
+	return rh.saveSync(*sync)
+}
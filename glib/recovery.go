@@ -0,0 +1,205 @@
+package glib
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// A channel that can publish replacement settings for the repo if the
+// owner's channel or wallet is compromised.  Its key should be kept offline.
+type glRecoveryChannel struct {
+	ClaimId     string `json:"claim_id"`
+	ChannelName string `json:"channel_name"`
+}
+
+// The lbry name recovery claims for the repo are published under
+func recoverName(originClaimId string) string {
+	return fmt.Sprintf("gitlbry-%v-recover", originClaimId)
+}
+
+func (s *glSettings) recoveryIds() []string {
+	return Map(s.Recovery, func(r *glRecoveryChannel) string { return r.ClaimId })
+}
+
+func (s *glSettings) isRecovery(channelId string) bool {
+	for _, r := range s.Recovery {
+		if r.ClaimId == channelId {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *glSettings) addRecovery(name string, channelId string) {
+	if !s.isRecovery(channelId) {
+		s.Recovery = append(s.Recovery, &glRecoveryChannel{ClaimId: channelId, ChannelName: name})
+	}
+}
+
+func (s *glSettings) removeRecovery(channelId string) {
+	var result []*glRecoveryChannel
+	for _, r := range s.Recovery {
+		if r.ClaimId != channelId {
+			result = append(result, r)
+		}
+	}
+	s.Recovery = result
+}
+
+// Pins the repo's origin and the recovery channels of the first settings that
+// named any.  Later settings can't change them since they may have been
+// published by whoever compromised the owner, only a recovery claim can.
+// Whatever the first settings name is trusted, so if the owner was already
+// compromised when they are pinned the attacker's channels are pinned.
+func (sync *Sync) pinRecovery(originClaimId string, settings *glSettings) {
+	if sync.Origin == "" {
+		sync.Origin = originClaimId
+	}
+
+	ids := settings.recoveryIds()
+	if len(sync.Recovery) == 0 {
+		if len(ids) > 0 {
+			OutPrintf("warning: trusting recovery channels %v from the current settings, check them with gitlbry recovery", ids)
+		}
+		sync.Recovery = ids
+	} else if len(ids) > 0 && !reflect.DeepEqual(ids, sync.Recovery) {
+		OutPrintf("ignoring changed recovery channels %v, pinned %v", ids, sync.Recovery)
+	}
+}
+
+// Finds the newest confirmed recovery claim for the repo signed by one of
+// the pinned recovery channels, nil if there is none
+func findRecoveryClaim(originClaimId string, pinned []string) (*sdkClaim, error) {
+
+	if len(pinned) == 0 {
+		return nil, nil
+	}
+
+	type arg struct {
+		Name                  string   `json:"name"`
+		ChannelIds            []string `json:"channel_ids"`
+		ValidChannelSignature bool     `json:"valid_channel_signature"`
+		PageSize              int      `json:"page_size"`
+		OrderBy               []string `json:"order_by"`
+	}
+
+	type out struct {
+		sdkClaim
+		IsChannelSignatureValid *bool `json:"is_channel_signature_valid"`
+	}
+
+	page, err := rpcCall[arg, sdkPage[*out]]("claim_search", arg{
+		Name:                  recoverName(originClaimId),
+		ChannelIds:            pinned,
+		ValidChannelSignature: true,
+		PageSize:              50,
+		OrderBy:               []string{"height"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	isPinned := map[string]bool{}
+	for _, id := range pinned {
+		isPinned[id] = true
+	}
+
+	for _, item := range page.Items {
+		if item.Error != nil ||
+			item.SigningChannel == nil ||
+			item.IsChannelSignatureValid == nil ||
+			!*item.IsChannelSignatureValid ||
+			!isPinned[item.SigningChannel.ClaimId] ||
+			item.Height <= 0 {
+			continue
+		}
+		return &item.sdkClaim, nil
+	}
+	return nil, nil
+}
+
+// Replaces the settings with those of the newest recovery claim if the repo
+// was recovered.  Returns the recovered settings and the recovery claim's id,
+// or the given settings and claim id if the repo wasn't recovered.  The
+// recovery channels are pinned in sync.json so that settings published with
+// a compromised owner channel can't change them.  Unless search is set the
+// recovery claim found by the last search, if any, is followed without
+// searching again
+func recoverSettings(originClaimId string, claimId string, settings *glSettings, sync *Sync, search bool) (*glSettings, string, error) {
+
+	sync.pinRecovery(originClaimId, settings)
+	originClaimId = sync.Origin
+
+	var found *sdkClaim
+	var err error
+	if search {
+		found, err = findRecoveryClaim(originClaimId, sync.Recovery)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Keep following an earlier recovery even if its channel is no longer
+	// pinned
+	url := sync.Recovered
+	if found != nil {
+		url = found.PermanentUrl
+	}
+	if url == "" {
+		return settings, claimId, nil
+	}
+
+	claim := found
+	if claim == nil {
+		claim, err = lbryResolve(url)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "error resolving %v, where the repo was recovered to", url)
+		}
+	}
+
+	path, err := newTempPath()
+	if err != nil {
+		return nil, "", err
+	}
+	recovered, err := downloadSettings(claim.PermanentUrl, path)
+	os.Remove(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if recovered.origin(claim.ClaimId) != originClaimId {
+		return nil, "", errors.Errorf("%v recovers another repo, not following the recovery", claim.PermanentUrl)
+	}
+	recovered.resolvePendingHeights(claim.Height)
+
+	if url != sync.Recovered {
+		OutPrintf("repo recovered to %v", url)
+		sync.Recovered = url
+		if len(recovered.Recovery) > 0 {
+			sync.Recovery = recovered.recoveryIds()
+		}
+	}
+
+	return recovered, claim.ClaimId, nil
+}
+
+// Returns the settings to publish as a recovery claim, based on trusted
+// settings e.g. a copy of settings.json from before the owner was
+// compromised.  predecessorId is the claim id of the settings being replaced.
+func (s *glSettings) recoveryOf(originClaimId string, predecessorId string) (*glSettings, error) {
+	next, err := s.successorOf(predecessorId)
+	if err != nil {
+		return nil, err
+	}
+	next.Origin = originClaimId
+
+	// The trusted copy may predate the current layout.  No client reads the
+	// new claim in the old layout, so it needn't be upgraded first
+	if next.Gitlbry < settingsLayoutVersion {
+		next.Gitlbry = settingsLayoutVersion
+	}
+	return next, nil
+}
@@ -0,0 +1,314 @@
+package glib
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Restricts how a patch may change the refs matching a pattern.  A patch
+// that breaks a rule is not canonical, as if it was never published
+type glRefRule struct {
+
+	// Pattern matched against full ref names as in path.Match, so * does not
+	// match / e.g. "refs/heads/main", "refs/tags/*", "refs/heads/release/*"
+	Ref string `json:"ref"`
+
+	// Only maintainers and the channels in Authors may update the ref
+	Maintainers bool `json:"maintainers,omitempty"`
+
+	// Claim ids of channels allowed to update the ref.  Anyone with push
+	// access if empty and Maintainers is false
+	Authors []string `json:"authors,omitempty"`
+
+	// The ref may be created but never moved
+	Immutable bool `json:"immutable,omitempty"`
+
+	// The ref may only move to a descendant of its current commit
+	FastForward bool `json:"fast_forward,omitempty"`
+
+	// Patches changing the ref are held until this many maintainers other
+	// than the author approve them, see checkApprovals
+	Approvals int `json:"approvals,omitempty"`
+
+	// Alternating heights at which the rule takes effect and is lifted, as in
+	// glAuthor.Heights.  A rule only applies to patches confirmed while it is
+	// in effect, so changing the rules doesn't reject patches clones already
+	// applied.  Empty for rules added before heights were recorded, which
+	// apply at every height
+	Heights []int64 `json:"heights,omitempty"`
+}
+
+func (r *glRefRule) matches(ref string) bool {
+	ok, err := path.Match(r.Ref, ref)
+	return err == nil && ok
+}
+
+// Returns true if the rule applies to patches confirmed at the height
+func (r *glRefRule) inEffectAt(height int64) bool {
+	return len(r.Heights) == 0 || inWindows(r.Heights, height)
+}
+
+// Returns true if the rule hasn't been lifted, including rules that take
+// effect once this settings update confirms
+func (r *glRefRule) current() bool {
+	return len(r.Heights)%2 == 1 || len(r.Heights) == 0
+}
+
+// Parses a rule given on the command line as <pattern>=<option>,<option>...
+// e.g. refs/heads/main=maintainers,fast-forward,approvals=2.  Options that aren't flags
+// are channel urls, returned unresolved so the caller can add their claim ids
+// to Authors
+func parseRefRule(x string) (*glRefRule, []string, error) {
+
+	pattern, spec, _ := strings.Cut(x, "=")
+	if !strings.HasPrefix(pattern, "refs/") {
+		return nil, nil, errors.Errorf("invalid ref pattern %v, expected a full ref name e.g. refs/heads/main", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, nil, errors.Errorf("invalid ref pattern %v", pattern)
+	}
+
+	rule := &glRefRule{Ref: pattern}
+	var channels []string
+	for _, opt := range strings.Split(spec, ",") {
+		switch opt {
+		case "":
+		case "maintainers":
+			rule.Maintainers = true
+		case "immutable":
+			rule.Immutable = true
+		case "fast-forward":
+			rule.FastForward = true
+		case "approvals":
+			return nil, nil, errors.Errorf("%v: expected approvals=<count>", x)
+		default:
+			if k, ok := strings.CutPrefix(opt, "approvals="); ok {
+				n, err := strconv.Atoi(k)
+				if err != nil || n < 1 {
+					return nil, nil, errors.Errorf("%v: invalid approvals %v, expected a count of at least 1", x, k)
+				}
+				rule.Approvals = n
+				continue
+			}
+			channels = append(channels, opt)
+		}
+	}
+
+	if !rule.Maintainers && !rule.Immutable && !rule.FastForward && rule.Approvals == 0 && len(channels) == 0 {
+		return nil, nil, errors.Errorf("%v: expected one or more of maintainers, immutable, fast-forward, approvals=<count> or a channel url", x)
+	}
+
+	return rule, channels, nil
+}
+
+func (r *glRefRule) String() string {
+	var opts []string
+	if r.Maintainers {
+		opts = append(opts, "maintainers")
+	}
+	if r.Immutable {
+		opts = append(opts, "immutable")
+	}
+	if r.FastForward {
+		opts = append(opts, "fast-forward")
+	}
+	if r.Approvals > 0 {
+		opts = append(opts, fmt.Sprintf("approvals=%v", r.Approvals))
+	}
+	opts = append(opts, r.Authors...)
+	return r.Ref + "=" + strings.Join(opts, ",")
+}
+
+// Returns the rules that haven't been lifted
+func (s *glSettings) currentRefRules() []*glRefRule {
+	var result []*glRefRule
+	for _, rule := range s.Refs {
+		if rule.current() {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+// Returns the current rule for the exact pattern, nil if there isn't one
+func (s *glSettings) refRule(pattern string) *glRefRule {
+	for _, rule := range s.currentRefRules() {
+		if rule.Ref == pattern {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Adds the rule from the height at which this settings update confirms,
+// lifting any current rule with the same pattern at that height
+func (s *glSettings) setRefRule(rule *glRefRule) {
+	s.removeRefRule(rule.Ref)
+	rule.Heights = []int64{pendingHeight}
+	s.Refs = append(s.Refs, rule)
+}
+
+// Lifts the current rule for the pattern from the height at which this
+// settings update confirms.  Lifted rules are kept so patches confirmed while
+// they were in effect are still checked against them
+func (s *glSettings) removeRefRule(pattern string) {
+	var kept []*glRefRule
+	for _, rule := range s.Refs {
+		switch {
+		case rule.Ref != pattern || !rule.current():
+		case len(rule.Heights) == 0:
+			rule.Heights = []int64{0, pendingHeight}
+		case rule.Heights[len(rule.Heights)-1] == pendingHeight:
+			// Added in this update, it never took effect
+			continue
+		default:
+			rule.Heights = append(rule.Heights, pendingHeight)
+		}
+		kept = append(kept, rule)
+	}
+	s.Refs = kept
+}
+
+// A change to a ref made by a patch.  Old is empty for a new ref and New is
+// empty for a deleted ref
+type refUpdate struct {
+	name string
+	old  string
+	new  string
+}
+
+// Returns an error describing the first rule the update breaks when made by
+// the given channel in a patch confirmed at height.  isAncestor reports
+// whether commit old is an ancestor of commit new
+func (s *glSettings) checkRefUpdate(channelId string, height int64, u refUpdate, isAncestor func(old string, new string) bool) error {
+
+	if u.old == u.new {
+		return nil
+	}
+
+	for _, rule := range s.Refs {
+		if !rule.matches(u.name) || !rule.inEffectAt(height) {
+			continue
+		}
+
+		if rule.Maintainers || len(rule.Authors) > 0 {
+			allowed := rule.Maintainers && s.isMaintainerAt(channelId, height)
+			for _, id := range rule.Authors {
+				allowed = allowed || id == channelId
+			}
+			if !allowed {
+				return errors.Errorf("%v: %v may not update refs matching %v", u.name, channelId, rule.Ref)
+			}
+		}
+
+		if rule.Immutable && u.old != "" {
+			return errors.Errorf("%v: refs matching %v may not be changed once created", u.name, rule.Ref)
+		}
+
+		if rule.FastForward && u.old != "" && (u.new == "" || !isAncestor(u.old, u.new)) {
+			return errors.Errorf("%v: refs matching %v may only be fast-forwarded", u.name, rule.Ref)
+		}
+	}
+
+	return nil
+}
+
+// Stores the objects in the bundle in the local clone without changing any
+// refs.  Returns the refs the bundle would set
+func (rh RepoName) unbundle(bundlePath string) ([]NamedRef, error) {
+
+	cmd := exec.Command("git", "bundle", "unbundle", bundlePath)
+	cmd.Dir = rh.gitRemoteClonePath()
+	out, err := cmd.CombinedOutput()
+	OutPrintf("git bundle unbundle %v", string(out))
+	if err != nil {
+		return nil, err
+	}
+
+	var heads []NamedRef
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		sha, name, ok := strings.Cut(line, " ")
+		if !ok || !strings.HasPrefix(name, "refs/") {
+			continue
+		}
+		ref, err := ShaFromHexString(sha)
+		if err != nil {
+			return nil, err
+		}
+		heads = append(heads, NamedRef{name: name, ref: ref})
+	}
+
+	return heads, nil
+}
+
+// Returns true if commit old is an ancestor of commit new in the local clone
+func (rh RepoName) isAncestor(old string, new string) bool {
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", old, new)
+	cmd.Dir = rh.gitRemoteClonePath()
+	return cmd.Run() == nil
+}
+
+// Checks the refs a patch confirmed at height sets against the settings' ref
+// rules, returning a rejectedBundleErr if it breaks one.  The patch's objects
+// must already be in the local clone
+func (rh RepoName) checkRefRules(channelId string, height int64, heads []NamedRef, settings *glSettings) error {
+
+	if len(settings.Refs) == 0 {
+		return nil
+	}
+
+	updates, err := rh.refUpdates(heads)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range updates {
+		err = settings.checkRefUpdate(channelId, height, u, rh.isAncestor)
+		if err != nil {
+			return &rejectedBundleErr{why: err}
+		}
+	}
+	return nil
+}
+
+// Returns the changes setting the heads would make to the refs in the local
+// clone
+func (rh RepoName) refUpdates(heads []NamedRef) ([]refUpdate, error) {
+
+	refs, err := rh.loadRefs()
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]string{}
+	for _, r := range refs {
+		current[r.name] = r.ref.toHexString()
+	}
+
+	var result []refUpdate
+	for _, head := range heads {
+		result = append(result, refUpdate{
+			name: head.name,
+			old:  current[head.name],
+			new:  head.ref.toHexString(),
+		})
+	}
+	return result, nil
+}
+
+// Points the refs in the local clone at the given commits
+func (rh RepoName) updateRefs(heads []NamedRef) error {
+	for _, head := range heads {
+		cmd := exec.Command("git", "update-ref", head.name, head.ref.toHexString())
+		cmd.Dir = rh.gitRemoteClonePath()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "git update-ref %v %s", head.name, out)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package glib
+
+import (
+	"testing"
+)
+
+func TestCheckRefUpdate(t *testing.T) {
+	s := &glSettings{
+		Authors: []*glAuthor{
+			{ClaimId: "aa"},
+			{ClaimId: "bb", Role: roleMaintainer},
+		},
+		Refs: []*glRefRule{
+			{Ref: "refs/heads/main", Maintainers: true, FastForward: true},
+			{Ref: "refs/tags/*", Immutable: true},
+			{Ref: "refs/heads/release/*", FastForward: true},
+		},
+	}
+
+	// Only "1" is an ancestor of "2"
+	isAncestor := func(old string, new string) bool { return old == "1" && new == "2" }
+
+	tests := []struct {
+		channel string
+		update  refUpdate
+		ok      bool
+	}{
+		{"aa", refUpdate{"refs/heads/main", "1", "2"}, false},
+		{"bb", refUpdate{"refs/heads/main", "1", "2"}, true},
+		{"bb", refUpdate{"refs/heads/main", "2", "3"}, false},
+		{"bb", refUpdate{"refs/heads/main", "", "3"}, true},
+		{"aa", refUpdate{"refs/heads/topic", "2", "3"}, true},
+		{"aa", refUpdate{"refs/tags/v1", "", "1"}, true},
+		{"aa", refUpdate{"refs/tags/v1", "1", "1"}, true},
+		{"bb", refUpdate{"refs/tags/v1", "1", "2"}, false},
+		{"aa", refUpdate{"refs/heads/release/1.0", "1", "2"}, true},
+		{"aa", refUpdate{"refs/heads/release/1.0", "1", ""}, false},
+		{"aa", refUpdate{"refs/heads/release/1.0/x", "2", "1"}, true},
+	}
+
+	for _, tt := range tests {
+		err := s.checkRefUpdate(tt.channel, 100, tt.update, isAncestor)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkRefUpdate(%v, %+v) = %v, want ok=%v", tt.channel, tt.update, err, tt.ok)
+		}
+	}
+}
+
+func TestRefRuleHeights(t *testing.T) {
+	s := &glSettings{
+		Authors: []*glAuthor{
+			{ClaimId: "aa"},
+			{ClaimId: "bb", Role: roleMaintainer, MaintainerHeights: []int64{0, 50}},
+		},
+	}
+	isAncestor := func(old string, new string) bool { return true }
+	main := refUpdate{"refs/heads/main", "1", "2"}
+
+	// Added at 20, after aa's patch at 10 which clones already applied
+	rule, _, err := parseRefRule("refs/heads/main=maintainers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setRefRule(rule)
+	s.resolvePendingHeights(20)
+
+	if err := s.checkRefUpdate("aa", 10, main, isAncestor); err != nil {
+		t.Errorf("patch confirmed before the rule was rejected: %v", err)
+	}
+	if err := s.checkRefUpdate("aa", 20, main, isAncestor); err == nil {
+		t.Errorf("patch confirmed after the rule was accepted")
+	}
+	if err := s.checkRefUpdate("bb", 40, main, isAncestor); err != nil {
+		t.Errorf("maintainer's patch was rejected: %v", err)
+	}
+	if err := s.checkRefUpdate("bb", 60, main, isAncestor); err == nil {
+		t.Errorf("demoted maintainer's patch was accepted")
+	}
+
+	// Lifted at 30
+	s.removeRefRule("refs/heads/main")
+	s.resolvePendingHeights(30)
+	if s.refRule("refs/heads/main") != nil || len(s.Refs) != 1 {
+		t.Errorf("lifted rule is still current: %+v", s.Refs)
+	}
+	if err := s.checkRefUpdate("aa", 25, main, isAncestor); err == nil {
+		t.Errorf("patch confirmed while the rule was in effect was accepted")
+	}
+	if err := s.checkRefUpdate("aa", 30, main, isAncestor); err != nil {
+		t.Errorf("patch confirmed after the rule was lifted was rejected: %v", err)
+	}
+
+	// Adding and removing in one update leaves no trace
+	s.setRefRule(&glRefRule{Ref: "refs/tags/*", Immutable: true})
+	s.removeRefRule("refs/tags/*")
+	if len(s.Refs) != 1 {
+		t.Errorf("rule that never took effect was kept: %+v", s.Refs)
+	}
+}
+
+func TestParseRefRule(t *testing.T) {
+	rule, channels, err := parseRefRule("refs/heads/main=maintainers,fast-forward,@alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Maintainers || !rule.FastForward || rule.Immutable || len(channels) != 1 || channels[0] != "@alice" {
+		t.Errorf("parseRefRule() = %+v, %v", rule, channels)
+	}
+
+	for _, bad := range []string{"refs/heads/main", "refs/heads/main=", "main=immutable", "refs/[=immutable"} {
+		if _, _, err := parseRefRule(bad); err == nil {
+			t.Errorf("parseRefRule(%q) should fail", bad)
+		}
+	}
+}
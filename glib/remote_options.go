@@ -0,0 +1,124 @@
+package glib
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Options for a single remote, given as query parameters on its url so that
+// one `git remote add` line describes how to talk to the repo e.g.
+// ```
+// lbry://repo?confirmations=6&bid=0.01&channel=@bot&snapshot=latest
+// ```
+type remoteOptions struct {
+
+	// confirmations=<n> Confirmations to wait for after a push, overrides the
+	// config.  nil if not given
+	confirmations *int
+
+	// bid=<lbc> Amount of lbc to bid when publishing a patch
+	bid string
+
+	// channel=<channel_url> Channel to push as, overrides gitlbry me.  Empty
+	// if not given
+	channel string
+
+	// snapshot=latest|<n> Stop syncing after patch n so the remote shows the
+	// repo as it was at that patch.  -1 for latest
+	snapshot int
+}
+
+// Bid used when the remote doesn't give one
+const defaultBid = "0.001"
+
+var remoteOptionNames = []string{"confirmations", "bid", "channel", "snapshot"}
+
+func parseRemoteOptions(u lbryUrl) (remoteOptions, error) {
+
+	opts := remoteOptions{
+		bid:      defaultBid,
+		snapshot: -1,
+	}
+
+	for _, param := range u.query {
+		switch param.name {
+		case "confirmations":
+			n, err := strconv.Atoi(param.value)
+			if err != nil || n < 0 {
+				return zero[remoteOptions](), errors.Errorf("invalid confirmations=%v, expected a number of blocks", param.value)
+			}
+			opts.confirmations = &n
+
+		case "bid":
+			x, err := strconv.ParseFloat(param.value, 64)
+			if err != nil || x <= 0 {
+				return zero[remoteOptions](), errors.Errorf("invalid bid=%v, expected an amount of lbc", param.value)
+			}
+			opts.bid = param.value
+
+		case "channel":
+			if param.value == "" {
+				return zero[remoteOptions](), errors.New("invalid channel=, expected a channel url")
+			}
+			opts.channel = param.value
+
+		case "snapshot":
+			snapshot, err := parseSnapshot(param.value)
+			if err != nil {
+				return zero[remoteOptions](), err
+			}
+			opts.snapshot = snapshot
+
+		default:
+			return zero[remoteOptions](), errors.Errorf("unknown remote option %v, expected one of %v", param.name, strings.Join(remoteOptionNames, ", "))
+		}
+	}
+
+	return opts, nil
+}
+
+func parseSnapshot(x string) (int, error) {
+	if x == "latest" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(x)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid snapshot=%v, expected latest or a patch index", x)
+	}
+	return n, nil
+}
+
+// Returns the number of confirmations to wait for after a push
+func (opts remoteOptions) confirmationsOr(cfg *glConfig, lbryUrl string) int {
+	if opts.confirmations != nil {
+		return *opts.confirmations
+	}
+	return cfg.confirmations(lbryUrl)
+}
+
+// Returns the channel to push as, either from the remote's options or from
+// gitlbry me
+func (opts remoteOptions) pushAs(cfg *glConfig) (*glChannel, error) {
+
+	if opts.channel == "" {
+		if cfg.Default.PushAs == nil {
+			return nil, errors.New("before pushing need to set author.  See gitlbry me <lbry_channel>\n")
+		}
+		return cfg.Default.PushAs, nil
+	}
+
+	ch, err := resolveChannel(opts.channel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error resolving channel=%v", opts.channel)
+	}
+	if !ch.isMine {
+		return nil, errors.Errorf("cannot push as %v:%v, you do not own this channel", ch.name, ch.claimId)
+	}
+
+	return &glChannel{
+		ClaimId: ch.claimId,
+		Name:    ch.name,
+	}, nil
+}
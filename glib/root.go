@@ -1,58 +1,141 @@
 package glib
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
 
 type glSettings struct {
 
-	// Version of gitlbry repo.  1 is the only supported value
+	// Version of the settings schema the document was written in, see
+	// settingsVersion
 	Gitlbry int         `json:"gitlbry"`
 	Authors []*glAuthor `json:"authors"`
-	Deleted []string    `jsion:"deleted"`
+	Deleted []string    `json:"deleted"`
+
+	// How patches are named on the lbry network, see patchName.  Absent for
+	// repos created before naming schemes were introduced
+	PatchNames int `json:"patch_names,omitempty"`
+
+	// Restrictions on which refs patches may change and how, see glRefRule
+	Refs []*glRefRule `json:"refs,omitempty"`
+
+	// Description of the repo, nil if it has never been set
+	Info *glRepoInfo `json:"info,omitempty"`
+
+	// Set when the repo was moved to a new settings claim by gitlbry
+	// transfer.  Origin is the claim id of the first settings claim, which
+	// patches are still named after.  Predecessor is the claim id of the
+	// settings claim this one replaced and Successor the permanent url of
+	// the one that replaced it.  See followSuccessors
+	Origin      string `json:"origin,omitempty"`
+	Predecessor string `json:"predecessor,omitempty"`
+	Successor   string `json:"successor,omitempty"`
+
+	// Block height from which patches are ignored, set by gitlbry freeze.
+	// Zero if the repo isn't frozen
+	FrozenAt int64 `json:"frozen_at,omitempty"`
+
+	// Earlier freezes, kept so that unfreezing doesn't accept patches
+	// published while the repo was frozen
+	Freezes []*glFreeze `json:"freezes,omitempty"`
+
+	// Channels that can publish replacement settings if the owner is
+	// compromised.  See recoverSettings
+	Recovery []*glRecoveryChannel `json:"recovery,omitempty"`
 }
 
-func (s *glSettings) grant(name string, channelId string, time int64) {
+// Patch naming schemes
+const (
+	// <stream_name>-<n>.  Patches of a repo named "tool" share names with
+	// the settings of a repo named "tool-3"
+	patchNamesLegacy = 0
 
-	for _, author := range s.Authors {
-		if author.ClaimId == channelId {
+	// gitlbry-<settings_claim_id>-<n>.  Unique to the repo
+	patchNamesClaimId = 1
+)
 
-			n := len(author.Times)
-			odd := n%2 == 1
-			if odd {
-				// Already have permissions
-				return
-			}
+// Scheme used for new repos
+const patchNamesLatest = patchNamesClaimId
 
-			author.Times = append(author.Times, time)
-			return
-		}
+// Returns the lbry name of the patch with the given index
+func (s *glSettings) patchName(rh RepoName, index int) string {
+	if s.PatchNames == patchNamesClaimId {
+		return fmt.Sprintf("gitlbry-%v-%v", s.origin(rh.claimId), index)
 	}
+	return fmt.Sprintf("%v-%v", rh.name, index)
+}
+
+// Stands in for the block height at which the settings update containing it
+// confirms.  Replaced with the real height when settings are read, see
+// resolvePendingHeights
+const pendingHeight = -1
 
-	// Add author to list
-	s.Authors = append(s.Authors, &glAuthor{
-		ClaimId:     channelId,
-		ChannelName: name,
-		Times: []int64{
-			time,
-		},
-	})
+// Allows the channel to push from the height at which this settings update
+// confirms.  next is the height it is expected to confirm at, see
+// heightRanges
+func (s *glSettings) grant(name string, channelId string, next int64) {
+	s.grantHeights(name, channelId, next, math.MaxInt64, next)
 }
 
-func (s *glSettings) revoke(name string, channelId string, time int64) {
+// Disallows the channel from pushing from the height at which this settings
+// update confirms, including in windows scheduled after it
+func (s *glSettings) revoke(name string, channelId string, next int64) {
+	OutPrintf("revoking from %v %v", name, channelId)
+	s.revokeHeights(name, channelId, next, math.MaxInt64, next)
+}
 
-	fmt.Printf("revoking from %v %v\n", name, channelId)
+// Replaces pending heights with the height at which the settings claim was
+// confirmed.  While the claim is unconfirmed (height <= 0) pending changes
+// don't take effect at any height
+func (s *glSettings) resolvePendingHeights(height int) {
+	h := int64(height)
+	if height <= 0 {
+		h = math.MaxInt64
+	}
 	for _, author := range s.Authors {
-		if author.ClaimId == channelId {
-
-			n := len(author.Times)
-			even := n%2 == 0
-			if even {
-				// Already revoked
-				return
+		for i, x := range author.Heights {
+			if x == pendingHeight {
+				author.Heights[i] = h
+			}
+		}
+		for i, x := range author.MaintainerHeights {
+			if x == pendingHeight {
+				author.MaintainerHeights[i] = h
+			}
+		}
+	}
+	for _, rule := range s.Refs {
+		for i, x := range rule.Heights {
+			if x == pendingHeight {
+				rule.Heights[i] = h
 			}
+		}
+	}
+	s.resolvePendingFreezes(h)
+}
 
-			author.Times = append(author.Times, time)
-			return
+// Settings used to record wall clock times, which drift from block times.
+// Ends each time window at now and continues the rest of it as a height
+// window, see scheduleHeight.  tip is the height at the unix time now
+func (s *glSettings) migrateTimesToHeights(now int64, tip int64) {
+	for _, author := range s.Authors {
+		var past []glRange
+		for _, r := range boundariesToRanges(author.Times) {
+			if r.Start < now {
+				past = append(past, newRange(r.Start, min64(r.end(), now)))
+			}
+			if r.end() > now {
+				start := scheduleHeight(max64(r.Start, now), now, tip)
+				s.grantHeights(author.ChannelName, author.ClaimId, start, scheduleHeight(r.end(), now, tip), tip+1)
+			}
 		}
+		author.Times = rangesToBoundaries(past)
 	}
 }
 
@@ -65,6 +148,148 @@ type glRange struct {
 	End int64 `json:"end"`
 }
 
+func (r glRange) contains(index int64) bool {
+	return r.Start <= index && (index < r.End || r.End == -1)
+}
+
+// End as a number that compares correctly
+func (r glRange) end() int64 {
+	if r.End == -1 {
+		return math.MaxInt64
+	}
+	return r.End
+}
+
+func newRange(start int64, end int64) glRange {
+	if end == math.MaxInt64 {
+		end = -1
+	}
+	return glRange{Start: start, End: end}
+}
+
+// Formats the range as inclusive patch indices e.g. "0-499" or "500-"
+func (r glRange) String() string {
+	if r.End == -1 {
+		return fmt.Sprintf("%v-", r.Start)
+	}
+	return fmt.Sprintf("%v-%v", r.Start, r.End-1)
+}
+
+// Parses inclusive patch indices e.g. "0-499" or "500-"
+func parseRange(x string) (glRange, error) {
+
+	startText, endText, ok := strings.Cut(x, "-")
+	if !ok {
+		return zero[glRange](), errors.Errorf("invalid patch range %v, expected <start>-<end> or <start>-", x)
+	}
+
+	start, err := strconv.ParseInt(startText, 10, 64)
+	if err != nil || start < 0 {
+		return zero[glRange](), errors.Errorf("invalid patch range %v, bad start", x)
+	}
+
+	if endText == "" {
+		return glRange{Start: start, End: -1}, nil
+	}
+
+	end, err := strconv.ParseInt(endText, 10, 64)
+	if err != nil || end < start {
+		return zero[glRange](), errors.Errorf("invalid patch range %v, bad end", x)
+	}
+
+	return glRange{Start: start, End: end + 1}, nil
+}
+
+// Returns the sorted union of the ranges, merging any that overlap or touch
+func unionRanges(ranges []glRange) []glRange {
+
+	sorted := append([]glRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var result []glRange
+	for _, r := range sorted {
+		n := len(result)
+		if n > 0 && r.Start <= result[n-1].end() {
+			if r.end() > result[n-1].end() {
+				result[n-1] = newRange(result[n-1].Start, r.end())
+			}
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// Returns the parts of ranges not covered by x
+func subtractRange(ranges []glRange, x glRange) []glRange {
+	var result []glRange
+	for _, r := range ranges {
+		if r.Start < x.Start {
+			result = append(result, newRange(r.Start, min64(r.end(), x.Start)))
+		}
+		if r.end() > x.end() {
+			result = append(result, newRange(max64(r.Start, x.end()), r.end()))
+		}
+	}
+	return result
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Allows the channel to publish patches with indices in the given range
+func (s *glSettings) grantRange(name string, channelId string, r glRange) {
+	author := s.author(channelId)
+	if author == nil {
+		author = &glAuthor{
+			ClaimId:     channelId,
+			ChannelName: name,
+		}
+		s.Authors = append(s.Authors, author)
+	}
+	author.Ranges = unionRanges(append(author.Ranges, r))
+}
+
+// Disallows the channel from publishing patches with indices in the given range
+func (s *glSettings) revokeRange(name string, channelId string, r glRange) {
+	OutPrintf("revoking patches %v from %v %v", r, name, channelId)
+	author := s.author(channelId)
+	if author != nil {
+		author.Ranges = subtractRange(author.Ranges, r)
+	}
+}
+
+// Disallows the channel from publishing patches with indices from the given
+// index on.  Used by revoke once it is known which patches are unpublished,
+// since removing a published patch from a range would change the canonical
+// chain
+func (s *glSettings) closeRanges(channelId string, from int64) {
+	author := s.author(channelId)
+	if author != nil {
+		author.Ranges = subtractRange(author.Ranges, newRange(from, math.MaxInt64))
+	}
+}
+
+func (s *glSettings) author(channelId string) *glAuthor {
+	for _, author := range s.Authors {
+		if author.ClaimId == channelId {
+			return author
+		}
+	}
+	return nil
+}
+
 type glAuthor struct {
 
 	// The lbry claim_id for the author's channel.  e.g. "e66aa0b46d98caf5aeafcee0bbb89bdafec0de72"
@@ -76,5 +301,77 @@ type glAuthor struct {
 	// These contain a list of increasing time stamps.  Times are in seconds from the unix epoch.
 	//  The first time stamp allows the channel push access after the gvien time
 	// the next entry disallows after the given time, the thrird re-allows asfter the gvien time etc.
-	Times []int64 `json:"ranges"`
+	// No longer written, see migrateTimesToHeights
+	Times []int64 `json:"times,omitempty"`
+
+	// Like Times but with block heights, which unlike wall clock times can't
+	// drift.  Grants and revokes take effect at the height where the settings
+	// update that made them confirmed, scheduled windows at the heights
+	// expected at their dates
+	Heights []int64 `json:"heights,omitempty"`
+
+	// Patch indices the channel may publish regardless of Times
+	Ranges []glRange `json:"ranges,omitempty"`
+
+	// rolePusher or roleMaintainer, empty for rolePusher
+	Role string `json:"role,omitempty"`
+
+	// Like Heights, but for when the channel is a maintainer.  Empty if the
+	// role never changed since role changes were recorded
+	MaintainerHeights []int64 `json:"maintainer_heights,omitempty"`
+}
+
+// Returns true if the author may publish the patch with the given index in a
+// block with the given time and height
+func (a *glAuthor) isAuthorized(timestamp int64, height int64, index int64) bool {
+
+	for _, r := range a.Ranges {
+		if r.contains(index) {
+			return true
+		}
+	}
+
+	return inWindows(a.Heights, height) || inWindows(a.Times, timestamp)
+}
+
+// Returns true if x is inside one of the windows described by an increasing
+// list of boundaries that alternately open and close a window
+func inWindows(boundaries []int64, x int64) bool {
+
+	i := 0
+	for ; i+1 < len(boundaries); i += 2 {
+		start := boundaries[i]
+		end := boundaries[i+1]
+		if start <= x && x < end {
+			return true
+		}
+	}
+
+	if i < len(boundaries) {
+		start := boundaries[i]
+		if start <= x {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Describes the author's current push access for display.  height is the
+// height at the unix time now
+func (a *glAuthor) status(now int64, height int64) string {
+
+	for i, h := range a.Heights {
+		if h == math.MaxInt64 {
+			if i%2 == 0 {
+				return "grant pending"
+			}
+			return "revoke pending"
+		}
+	}
+
+	if inWindows(a.Heights, height) || inWindows(a.Times, now) {
+		return "granted"
+	}
+	return "revoked"
 }
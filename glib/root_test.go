@@ -0,0 +1,366 @@
+package glib
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAuthorIsAuthorized(t *testing.T) {
+	author := &glAuthor{
+		Times:   []int64{100, 200, 300},
+		Heights: []int64{10, 20},
+		Ranges:  []glRange{{Start: 0, End: 500}, {Start: 1000, End: -1}},
+	}
+	tests := []struct {
+		timestamp int64
+		height    int64
+		index     int64
+		want      bool
+	}{
+		{timestamp: 50, index: 0, want: true},
+		{timestamp: 50, index: 499, want: true},
+		{timestamp: 50, index: 500, want: false},
+		{timestamp: 50, index: 5000, want: true},
+		{timestamp: 150, index: 600, want: true},
+		{timestamp: 200, index: 600, want: false},
+		{timestamp: 300, index: 600, want: true},
+		{timestamp: 50, height: 10, index: 600, want: true},
+		{timestamp: 50, height: 20, index: 600, want: false},
+	}
+	for _, tt := range tests {
+		got := author.isAuthorized(tt.timestamp, tt.height, tt.index)
+		if got != tt.want {
+			t.Errorf("isAuthorized(%v, %v, %v) = %v, want %v", tt.timestamp, tt.height, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestPendingHeights(t *testing.T) {
+	var s glSettings
+	s.grant("alice", "a", 1)
+	s.grant("alice", "a", 1)
+	s.grant("bob", "b", 1)
+
+	s.resolvePendingHeights(100)
+	s.revoke("alice", "a", 101)
+
+	if !reflect.DeepEqual(s.author("a").Heights, []int64{100, pendingHeight}) {
+		t.Errorf("alice heights = %v", s.author("a").Heights)
+	}
+
+	// Unconfirmed changes don't take effect
+	s.resolvePendingHeights(0)
+	if !s.isAuthorized("a", 0, 5000, 10000) || s.author("a").status(0, 0) != "revoke pending" {
+		t.Errorf("unconfirmed revoke took effect %v", s.author("a").Heights)
+	}
+	if !s.isAuthorized("b", 0, 100, 10000) || s.isAuthorized("b", 0, 99, 10000) {
+		t.Errorf("bob heights = %v", s.author("b").Heights)
+	}
+}
+
+func TestMigrateTimesToHeights(t *testing.T) {
+	s := glSettings{
+		Authors: []*glAuthor{
+			{ClaimId: "open", Times: []int64{100}},
+			{ClaimId: "closed", Times: []int64{100, 200}},
+		},
+	}
+	s.migrateTimesToHeights(300, 0)
+
+	if !reflect.DeepEqual(s.author("open").Times, []int64{100, 300}) || !reflect.DeepEqual(s.author("open").Heights, []int64{pendingHeight}) {
+		t.Errorf("open author = %+v", s.author("open"))
+	}
+	if !reflect.DeepEqual(s.author("closed").Times, []int64{100, 200}) || s.author("closed").Heights != nil {
+		t.Errorf("closed author = %+v", s.author("closed"))
+	}
+}
+
+func TestRanges(t *testing.T) {
+	r, err := parseRange("0-499")
+	if err != nil || r != (glRange{Start: 0, End: 500}) || r.String() != "0-499" {
+		t.Errorf("parseRange(0-499) = %v, %v", r, err)
+	}
+	r, err = parseRange("500-")
+	if err != nil || r != (glRange{Start: 500, End: -1}) || r.String() != "500-" {
+		t.Errorf("parseRange(500-) = %v, %v", r, err)
+	}
+	for _, bad := range []string{"", "5", "-5", "5-4", "a-b"} {
+		if _, err := parseRange(bad); err == nil {
+			t.Errorf("parseRange(%q) should fail", bad)
+		}
+	}
+
+	union := unionRanges([]glRange{{Start: 10, End: 20}, {Start: 0, End: 5}, {Start: 5, End: 12}, {Start: 30, End: -1}, {Start: 40, End: 50}})
+	want := []glRange{{Start: 0, End: 20}, {Start: 30, End: -1}}
+	if !reflect.DeepEqual(union, want) {
+		t.Errorf("unionRanges() = %v, want %v", union, want)
+	}
+
+	diff := subtractRange(want, glRange{Start: 10, End: 40})
+	want = []glRange{{Start: 0, End: 10}, {Start: 40, End: -1}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("subtractRange() = %v, want %v", diff, want)
+	}
+}
+
+func TestDecodeSettings(t *testing.T) {
+	v1 := `{"gitlbry":1,"Deleted":["cc"],"authors":[
+		{"claim_id":"ab","channel_name":"alice","ranges":[100,200]},
+		{"claim_id":"cd","channel_name":"bob","ranges":[{"start":0,"end":5}]}]}`
+
+	s, err := decodeSettings([]byte(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Gitlbry != 1 || !reflect.DeepEqual(s.Deleted, []string{"cc"}) {
+		t.Errorf("v1 settings = %+v", s)
+	}
+	if a := s.author("ab"); !reflect.DeepEqual(a.Times, []int64{100, 200}) || a.Ranges != nil {
+		t.Errorf("legacy author = %+v", a)
+	}
+	if a := s.author("cd"); !reflect.DeepEqual(a.Ranges, []glRange{{Start: 0, End: 5}}) || a.Times != nil {
+		t.Errorf("ranges author = %+v", a)
+	}
+
+	// Writing and reading back as the latest version gives the same settings
+	s.Gitlbry = settingsVersion
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := decodeSettings(b)
+	if err != nil || !reflect.DeepEqual(s, again) {
+		t.Errorf("round trip = %+v, %v want %+v", again, err, s)
+	}
+
+	// v1 documents must be upgraded before they are written
+	if _, err := encodeSettings(&glSettings{Gitlbry: 1}); err == nil {
+		t.Errorf("encodeSettings() should refuse version 1")
+	}
+	s.Gitlbry = settingsLayoutVersion
+	if _, err := encodeSettings(s); err != nil || s.Gitlbry != 3 {
+		t.Errorf("settings with deleted patches written as version %v, %v", s.Gitlbry, err)
+	}
+	s.Deleted = nil
+	if _, err := encodeSettings(s); err != nil || s.Gitlbry != settingsLayoutVersion {
+		t.Errorf("plain settings written as version %v, %v", s.Gitlbry, err)
+	}
+
+	for _, bad := range []string{`{"authors":[]}`, `{"gitlbry":99}`, `{"gitlbry":2,"authors":[{"ranges":[100]}]}`} {
+		if _, err := decodeSettings([]byte(bad)); err == nil {
+			t.Errorf("decodeSettings(%v) should fail", bad)
+		}
+	}
+}
+
+func TestApplyAmendments(t *testing.T) {
+	s := &glSettings{Authors: []*glAuthor{
+		{ClaimId: "aa", ChannelName: "alice", Heights: []int64{10, 50}},
+		{ClaimId: "bb", ChannelName: "bob", Heights: []int64{10}, Role: roleMaintainer},
+	}}
+
+	s.applyAmendments([]*amendmentClaim{
+		{height: 20, amendment: &glAmendment{Revoke: []*glAmendedAuthor{{ClaimId: "aa"}}}},
+		{height: 50, amendment: &glAmendment{Grant: []*glAmendedAuthor{{ClaimId: "aa"}}}},
+		{height: 60, amendment: &glAmendment{Grant: []*glAmendedAuthor{{ClaimId: "cc", ChannelName: "carol"}}}},
+		{height: 70, amendment: &glAmendment{Revoke: []*glAmendedAuthor{{ClaimId: "bb"}}}},
+	})
+
+	// The owner's revoke at 50 wins over the grant at the same height
+	if got := s.author("aa").Heights; !reflect.DeepEqual(got, []int64{10, 20}) {
+		t.Errorf("alice heights = %v", got)
+	}
+	if got := s.author("cc"); got == nil || !reflect.DeepEqual(got.Heights, []int64{60}) {
+		t.Errorf("carol = %+v", got)
+	}
+	if got := s.author("bb").Heights; !reflect.DeepEqual(got, []int64{10}) {
+		t.Errorf("maintainer heights = %v", got)
+	}
+}
+
+func TestSyncAuthors(t *testing.T) {
+	s := &glSettings{Authors: []*glAuthor{
+		{ClaimId: "aa", ChannelName: "alice", Heights: []int64{10}},
+		{ClaimId: "bb", ChannelName: "bob", Heights: []int64{10}, Role: roleMaintainer},
+		{ClaimId: "cc", ChannelName: "carol", Ranges: []glRange{{Start: 0, End: 5}}},
+	}}
+
+	changes := s.syncAuthors([]*desiredAuthor{
+		{name: "alice", claimId: "aa", role: roleMaintainer},
+		{name: "carol", claimId: "cc", role: rolePusher, ranges: []glRange{{Start: 0, End: 10}}},
+		{name: "dan", claimId: "dd", role: rolePusher},
+	}, 0, 100)
+
+	want := []string{
+		"alice:aa role pusher -> maintainer",
+		"carol:cc patches 0-4 -> 0-9",
+		"dan:dd grant",
+		"bob:bb revoke",
+		"bob:bb role maintainer -> pusher",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("syncAuthors() = %q, want %q", changes, want)
+	}
+	if s.author("bb").canPush(0, 100) || !s.author("dd").canPush(0, 100) || s.author("aa").role() != roleMaintainer {
+		t.Errorf("settings after sync = %+v", s.Authors)
+	}
+
+	if changes := s.syncAuthors([]*desiredAuthor{
+		{name: "alice", claimId: "aa", role: roleMaintainer},
+		{name: "carol", claimId: "cc", role: rolePusher, ranges: []glRange{{Start: 0, End: 10}}},
+		{name: "dan", claimId: "dd", role: rolePusher},
+	}, 0, 100); len(changes) != 0 {
+		t.Errorf("second sync changed %q", changes)
+	}
+}
+
+func TestScheduledHeights(t *testing.T) {
+
+	// Height 1000 at time 0, a block every 150 seconds
+	if h := scheduleHeight(-100, 0, 1000); h != 1001 {
+		t.Errorf("scheduleHeight() of a past time = %v", h)
+	}
+	if h := scheduleHeight(1500, 0, 1000); h != 1010 {
+		t.Errorf("scheduleHeight() = %v", h)
+	}
+
+	s := &glSettings{}
+	s.grantHeights("carol", "cc", 1100, 1200, 1001)
+	s.grantHeights("carol", "cc", 1150, 1300, 1001)
+	s.grantHeights("carol", "cc", 1500, math.MaxInt64, 1001)
+
+	author := s.author("cc")
+	if !reflect.DeepEqual(author.Heights, []int64{1100, 1300, 1500}) {
+		t.Errorf("heights = %v", author.Heights)
+	}
+	if author.isAuthorized(0, 1050, 0) || !author.isAuthorized(0, 1120, 0) || author.isAuthorized(0, 1400, 0) {
+		t.Errorf("isAuthorized wrong for %v", author.Heights)
+	}
+	want := "from block 1100 (about 1970-01-01T04:10:00Z) until block 1300 (about 1970-01-01T12:30:00Z), from block 1500 (about 1970-01-01T20:50:00Z)"
+	if got := author.schedule(0, 1000); got != want {
+		t.Errorf("schedule() = %q", got)
+	}
+
+	s.revokeHeights("carol", "cc", 1200, 1600, 1001)
+	if !reflect.DeepEqual(author.Heights, []int64{1100, 1200, 1600}) {
+		t.Errorf("heights after revoke = %v", author.Heights)
+	}
+
+	// Revoking with no schedule also ends the scheduled windows
+	s.revoke("carol", "cc", 1001)
+	if len(author.Heights) != 0 {
+		t.Errorf("heights after revoke with no schedule = %v", author.Heights)
+	}
+
+	// Scheduled times written by older versions become heights
+	old := &glSettings{Authors: []*glAuthor{{ClaimId: "dd", Times: []int64{-300, 300, 1500}}}}
+	old.migrateTimesToHeights(0, 1000)
+	if a := old.author("dd"); !reflect.DeepEqual(a.Times, []int64{-300, 0}) || !reflect.DeepEqual(a.Heights, []int64{pendingHeight, 1002, 1010}) {
+		t.Errorf("migrated = %+v", a)
+	}
+}
+
+func TestSuccessorPatchNames(t *testing.T) {
+	rh := RepoName{name: "tool", claimId: "aa"}
+	s := &glSettings{PatchNames: patchNamesClaimId}
+
+	next, err := s.successorOf("aa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := next.successorOf("bb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rh.claimId = "cc"
+	if again.Origin != "aa" || again.Predecessor != "bb" || again.patchName(rh, 3) != "gitlbry-aa-3" {
+		t.Errorf("successor = %+v, patch %v", again, again.patchName(rh, 3))
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	s := &glSettings{}
+
+	s.freeze()
+	pending := &glSettings{FrozenAt: s.FrozenAt}
+	pending.resolvePendingHeights(0)
+	if !pending.isFrozen() || pending.isFrozenAt(100) || !pending.isFrozenAt(-1) || pending.freezeStatus() != "freeze pending" {
+		t.Errorf("pending freeze = %+v", pending)
+	}
+	s.resolvePendingHeights(100)
+	if s.isFrozenAt(99) || !s.isFrozenAt(100) || s.freezeStatus() != "frozen at height 100" {
+		t.Errorf("freeze = %+v", s)
+	}
+
+	s.unfreeze()
+	s.resolvePendingHeights(150)
+	if s.isFrozen() || s.isFrozenAt(99) || !s.isFrozenAt(149) || s.isFrozenAt(150) {
+		t.Errorf("unfreeze = %+v %+v", s, s.Freezes)
+	}
+	// A patch applied before it confirmed inside the freeze is rolled back
+	cache := &claimCache{Patches: []*cachedClaim{{ClaimId: "p0", Height: 90}, {ClaimId: "p1", Height: 120}, {ClaimId: "p2", Height: 160}}}
+	if i := cache.firstRejectedPatch(s, 3); i != 1 {
+		t.Errorf("firstRejectedPatch() = %v", i)
+	}
+	if i := cache.firstRejectedPatch(s, 1); i != -1 {
+		t.Errorf("firstRejectedPatch() of unaffected patches = %v", i)
+	}
+}
+
+func TestPinRecovery(t *testing.T) {
+	s := &glSettings{}
+	s.addRecovery("@safe", "aa")
+	c := &Sync{}
+	c.pinRecovery("or", s)
+
+	// Settings published by whoever took the owner's channel
+	s.removeRecovery("aa")
+	s.addRecovery("@evil", "ee")
+	c.pinRecovery("other", s)
+	if c.Origin != "or" || !reflect.DeepEqual(c.Recovery, []string{"aa"}) {
+		t.Errorf("pinned %v %v", c.Origin, c.Recovery)
+	}
+
+	next, err := s.recoveryOf("or", "bb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Origin != "or" || next.Predecessor != "bb" || !next.isRecovery("ee") {
+		t.Errorf("recovery = %+v", next)
+	}
+}
+
+func TestCloseRanges(t *testing.T) {
+	s := &glSettings{}
+	s.grantRange("bob", "bb", glRange{Start: 0, End: 10})
+	s.grantRange("bob", "bb", glRange{Start: 20, End: -1})
+	s.closeRanges("bb", 5)
+	if got := s.author("bb").Ranges; !reflect.DeepEqual(got, []glRange{{Start: 0, End: 5}}) {
+		t.Errorf("ranges = %v", got)
+	}
+}
+
+func TestMaintainerAt(t *testing.T) {
+	s := &glSettings{Authors: []*glAuthor{
+		{ClaimId: "bb", ChannelName: "bob", Heights: []int64{10}, Role: roleMaintainer},
+	}}
+	if !s.isMaintainerAt("bb", 5) {
+		t.Errorf("legacy maintainer should count from the start")
+	}
+
+	// Amendments made before the demotion still apply
+	s.setRole("bob", "bb", rolePusher)
+	s.resolvePendingHeights(100)
+	if !s.isMaintainerAt("bb", 99) || s.isMaintainerAt("bb", 100) || s.isMaintainer("bb") {
+		t.Errorf("demoted maintainer heights = %v", s.author("bb").MaintainerHeights)
+	}
+
+	s.setRole("carol", "cc", roleMaintainer)
+	s.resolvePendingHeights(200)
+	if s.isMaintainerAt("cc", 199) || !s.isMaintainerAt("cc", 200) {
+		t.Errorf("promoted maintainer heights = %v", s.author("cc").MaintainerHeights)
+	}
+}
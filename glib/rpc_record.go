@@ -0,0 +1,177 @@
+package glib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// When set, every rpc request and response is appended to the named file as
+// one json object per line.  Files downloaded with get are recorded too.
+const rpcRecordEnv = "GITLBRY_RPC_RECORD"
+
+// When set, responses are served from the named recording in order instead of
+// from the lbrynet daemon.
+const rpcReplayEnv = "GITLBRY_RPC_REPLAY"
+
+// One line of a recording
+type rpcRecord struct {
+	// The rpc method, or "file" for the contents of a downloaded file
+	Method   string          `json:"method"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Data     []byte          `json:"data,omitempty"`
+}
+
+const rpcRecordFileMethod = "file"
+
+// Records remaining to be served when replaying, nil if not replaying
+var rpcReplayRecords []*rpcRecord
+var rpcReplayLoaded bool
+
+func isRecording() bool {
+	return os.Getenv(rpcRecordEnv) != ""
+}
+
+func isReplaying() bool {
+	return os.Getenv(rpcReplayEnv) != ""
+}
+
+// Sends the json encoded request to the lbrynet daemon, or to the recording
+// when replaying, and returns the raw response
+func rpcSend(method string, request []byte) ([]byte, error) {
+
+	if isReplaying() {
+		r, err := nextReplayRecord(method)
+		if err != nil {
+			return nil, err
+		}
+		return r.Response, nil
+	}
+
+	resp, err := http.Post(lbryrpcServer, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return nil, errors.Wrap(err, "error durring http.POST to lbrynet rpc server")
+	}
+	defer resp.Body.Close()
+
+	buf := streamToByte(resp.Body)
+	err = appendRecord(&rpcRecord{
+		Method:   method,
+		Request:  request,
+		Response: buf,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Saves the contents of a file downloaded from the lbry network to the
+// recording.  Does nothing if not recording
+func rpcRecordFile(path string) error {
+
+	if !isRecording() {
+		return nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return appendRecord(&rpcRecord{
+		Method: rpcRecordFileMethod,
+		Data:   b,
+	})
+}
+
+// Writes the next recorded file to fileName
+func rpcReplayFile(fileName string) error {
+	r, err := nextReplayRecord(rpcRecordFileMethod)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, r.Data, 0666)
+}
+
+func appendRecord(r *rpcRecord) error {
+
+	path := os.Getenv(rpcRecordEnv)
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	fid, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return errors.Wrap(err, "error opening rpc recording")
+	}
+	defer fid.Close()
+
+	_, err = fid.Write(append(b, '\n'))
+	return err
+}
+
+// Pops the next record from the recording.  Replay is strictly ordered, so
+// the record must be for the expected method.
+func nextReplayRecord(method string) (*rpcRecord, error) {
+
+	if !rpcReplayLoaded {
+		records, err := loadRecording(os.Getenv(rpcReplayEnv))
+		if err != nil {
+			return nil, err
+		}
+		rpcReplayRecords = records
+		rpcReplayLoaded = true
+	}
+
+	if len(rpcReplayRecords) == 0 {
+		return nil, errors.Errorf("rpc replay: recording has no response for %v", method)
+	}
+
+	r := rpcReplayRecords[0]
+	if r.Method != method {
+		return nil, errors.Errorf("rpc replay: expected %v but recording has %v", method, r.Method)
+	}
+
+	rpcReplayRecords = rpcReplayRecords[1:]
+	return r, nil
+}
+
+func loadRecording(path string) ([]*rpcRecord, error) {
+
+	fid, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening rpc recording")
+	}
+	defer fid.Close()
+
+	var records []*rpcRecord
+	scanner := bufio.NewScanner(fid)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var r rpcRecord
+		err = json.Unmarshal(line, &r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading rpc recording line %v", len(records)+1)
+		}
+		records = append(records, &r)
+	}
+
+	return records, scanner.Err()
+}
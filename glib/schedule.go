@@ -0,0 +1,177 @@
+package glib
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Time windows scheduled with gitlbry author --from --until.  Dates are
+// converted to the block heights expected at those times, since wall clock
+// times drift from block times.  Settings written before that kept scheduled
+// windows in Times, which migrateTimesToHeights converts the same way.
+
+// Average seconds between lbry blocks
+const secondsPerBlock = 150
+
+// Returns the height expected at the unix time t given the height tip at the
+// unix time now.  Times before the next block map to tip + 1, which stands in
+// for the height the settings update confirms at, see heightRanges.
+// math.MaxInt64 stays as is
+func scheduleHeight(t int64, now int64, tip int64) int64 {
+	if t == math.MaxInt64 {
+		return t
+	}
+	next := tip + 1
+	if t <= now {
+		return next
+	}
+	return max64(next, tip+(t-now+secondsPerBlock-1)/secondsPerBlock)
+}
+
+// Converts alternating grant and revoke boundaries into windows, an odd
+// number of boundaries leaves the last window open
+func boundariesToRanges(boundaries []int64) []glRange {
+	var result []glRange
+	for i := 0; i < len(boundaries); i += 2 {
+		end := int64(math.MaxInt64)
+		if i+1 < len(boundaries) {
+			end = boundaries[i+1]
+		}
+		result = append(result, newRange(boundaries[i], end))
+	}
+	return result
+}
+
+func rangesToBoundaries(ranges []glRange) []int64 {
+	var result []int64
+	for _, r := range ranges {
+		result = append(result, r.Start)
+		if r.End != -1 {
+			result = append(result, r.End)
+		}
+	}
+	return result
+}
+
+// Returns the author's height windows with pending boundaries at next, the
+// height the settings update is expected to confirm at
+func (a *glAuthor) heightRanges(next int64) []glRange {
+	return unionRanges(boundariesToRanges(Map(a.Heights, func(h int64) int64 {
+		if h == pendingHeight {
+			return next
+		}
+		return h
+	})))
+}
+
+// Sets the author's heights to the windows, boundaries at next become
+// pending so that they take effect where the settings update confirms.  A
+// window scheduled earlier that happens to start or end at next moves to
+// that height too
+func (a *glAuthor) setHeightRanges(ranges []glRange, next int64) {
+	a.Heights = Map(rangesToBoundaries(ranges), func(h int64) int64 {
+		if h == next {
+			return pendingHeight
+		}
+		return h
+	})
+}
+
+// Allows the channel to push from block height start until end, end is
+// math.MaxInt64 for no end.  Heights before next are treated as next
+func (s *glSettings) grantHeights(name string, channelId string, start int64, end int64, next int64) {
+	author := s.author(channelId)
+	if author == nil {
+		author = &glAuthor{
+			ClaimId:     channelId,
+			ChannelName: name,
+		}
+		s.Authors = append(s.Authors, author)
+	}
+	start = max64(start, next)
+	if end <= start {
+		return
+	}
+	windows := append(author.heightRanges(next), newRange(start, end))
+	author.setHeightRanges(unionRanges(windows), next)
+}
+
+// Disallows the channel from pushing from block height start until end where
+// it was allowed by Heights.  Heights before next are treated as next
+func (s *glSettings) revokeHeights(name string, channelId string, start int64, end int64, next int64) {
+	OutPrintf("revoking heights %v-%v from %v %v", start, end, name, channelId)
+	author := s.author(channelId)
+	if author == nil {
+		return
+	}
+	start = max64(start, next)
+	if end <= start {
+		return
+	}
+	author.setHeightRanges(subtractRange(author.heightRanges(next), newRange(start, end)), next)
+}
+
+// Describes the author's current and future scheduled windows for display
+// e.g. "until block 1500000 (about 2024-06-30T00:00:00Z)", empty if there are
+// none.  tip is the height at the unix time now
+func (a *glAuthor) schedule(now int64, tip int64) string {
+
+	format := func(x int64) string {
+		return time.Unix(x, 0).UTC().Format(time.RFC3339)
+	}
+	block := func(h int64) string {
+		return fmt.Sprintf("block %v (about %v)", h, format(now+(h-tip)*secondsPerBlock))
+	}
+
+	var parts []string
+	describe := func(r glRange, current int64, bound func(int64) string) {
+		if r.end() <= current {
+			return
+		}
+		var part []string
+		if r.Start > current {
+			part = append(part, "from "+bound(r.Start))
+		}
+		if r.End != -1 {
+			part = append(part, "until "+bound(r.End))
+		}
+		if len(part) > 0 {
+			parts = append(parts, strings.Join(part, " "))
+		}
+	}
+
+	for _, r := range boundariesToRanges(a.Times) {
+		describe(r, now, format)
+	}
+
+	// Pending boundaries are shown by status
+	for _, r := range boundariesToRanges(a.Heights) {
+		if r.Start != math.MaxInt64 {
+			describe(r, tip, block)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Parses a date given to --from or --until as either an RFC3339 time or a
+// local date.  A date given to --until means the end of that day
+func parseScheduleDate(x string, until bool) (int64, error) {
+
+	t, err := time.Parse(time.RFC3339, x)
+	if err == nil {
+		return t.Unix(), nil
+	}
+
+	t, err = time.ParseInLocation("2006-01-02", x, time.Local)
+	if err != nil {
+		return 0, errors.Errorf("invalid date %v, expected YYYY-MM-DD or an RFC3339 time", x)
+	}
+	if until {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,242 @@
+package glib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A lbrynet sdk release e.g. 0.113.0
+type sdkVersion struct {
+	major int
+	minor int
+	patch int
+}
+
+func (v sdkVersion) String() string {
+	return fmt.Sprintf("%v.%v.%v", v.major, v.minor, v.patch)
+}
+
+func (v sdkVersion) atLeast(o sdkVersion) bool {
+	if v.major != o.major {
+		return v.major > o.major
+	}
+	if v.minor != o.minor {
+		return v.minor > o.minor
+	}
+	return v.patch >= o.patch
+}
+
+// Parses versions reported by the sdk e.g. "0.113.0" or "v0.113.0"
+func parseSdkVersion(x string) (sdkVersion, error) {
+
+	parts := strings.SplitN(strings.TrimPrefix(x, "v"), ".", 3)
+	if len(parts) != 3 {
+		return zero[sdkVersion](), errors.Errorf("unrecognized lbrynet version %q", x)
+	}
+
+	// Ignore suffixes such as "0.113.0rc1"
+	end := strings.IndexFunc(parts[2], func(r rune) bool { return !isDigitChar(r) })
+	if end != -1 {
+		parts[2] = parts[2][:end]
+	}
+
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return zero[sdkVersion](), errors.Errorf("unrecognized lbrynet version %q", x)
+		}
+		nums[i] = n
+	}
+
+	return sdkVersion{nums[0], nums[1], nums[2]}, nil
+}
+
+// Oldest sdk release gitlbry works with, the one it is tested against.
+// Besides resolve and claim_search it relies on txo_list, transaction_show
+// with decoded claim values, the valid_channel_signature filter of
+// claim_search, clear_channel and claim_address in stream_update and
+// blob_get.  Older releases lack some of these and aren't tested, so rather
+// than guess which they lack they are refused
+var minSdkVersion = sdkVersion{0, 113, 0}
+
+// Newest sdk release gitlbry is tested against.  Later 0.x releases are used
+// with a warning
+var maxTestedSdkVersion = sdkVersion{0, 113, 0}
+
+// Describes the running lbrynet sdk
+type sdkCompat struct {
+	version sdkVersion
+}
+
+func newSdkCompat(v sdkVersion) (*sdkCompat, error) {
+
+	if !v.atLeast(minSdkVersion) {
+		return nil, errors.Errorf("lbrynet %v is not supported, please upgrade to %v or later", v, minSdkVersion)
+	}
+
+	if v.major > 0 {
+		return nil, errors.Errorf("lbrynet %v is not supported by this version of gitlbry, check for a gitlbry update", v)
+	}
+
+	return &sdkCompat{version: v}, nil
+}
+
+// True if gitlbry hasn't been tested with a release this new
+func (c *sdkCompat) untested() bool {
+	return c.version.minor > maxTestedSdkVersion.minor
+}
+
+// Cached result of lbrySdk
+var currentSdk *sdkCompat
+
+// How long later runs reuse the version lbrySdk found.  The daemon only
+// changes version when it is restarted
+const sdkVersionTtl = time.Hour
+
+// The version lbrySdk found, kept between runs
+type cachedSdkVersion struct {
+	Version string `json:"version"`
+
+	// Unix time the daemon was asked
+	Checked int64 `json:"checked"`
+}
+
+func sdkVersionPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gitlbry", "sdk.json"), nil
+}
+
+// Returns the version found by an earlier run within sdkVersionTtl, empty if
+// there isn't one.  Recordings always ask the daemon so that they replay
+// the same
+func loadSdkVersion(now int64) string {
+	path, err := sdkVersionPath()
+	if err != nil || isRecording() || isReplaying() {
+		return ""
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var c cachedSdkVersion
+	err = json.Unmarshal(b, &c)
+	if err != nil || now-c.Checked >= int64(sdkVersionTtl.Seconds()) || now < c.Checked {
+		return ""
+	}
+	return c.Version
+}
+
+func saveSdkVersion(version string, now int64) error {
+	path, err := sdkVersionPath()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&cachedSdkVersion{Version: version, Checked: now})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0666)
+}
+
+// Returns how to talk to the running sdk, querying its version at most once
+// per sdkVersionTtl.  Returns an error if the running sdk is not supported.
+func lbrySdk() (*sdkCompat, error) {
+
+	if currentSdk != nil {
+		return currentSdk, nil
+	}
+
+	now := time.Now().Unix()
+	raw := loadSdkVersion(now)
+	cached := raw != ""
+	if !cached {
+		var err error
+		raw, err = querySdkVersion()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := parseSdkVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	sdk, err := newSdkCompat(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unsupported versions aren't kept so an upgrade is noticed at once
+	if !cached {
+		err = saveSdkVersion(raw, now)
+		if err != nil {
+			OutPrintf("error saving lbrynet version: %v", err)
+		}
+	}
+
+	OutPrintf("using lbrynet %v", v)
+	if sdk.untested() {
+		OutPrintf("warning: gitlbry is only tested with lbrynet %v, check for a gitlbry update", maxTestedSdkVersion)
+	}
+	currentSdk = sdk
+	return sdk, nil
+}
+
+// Asks the daemon for its version
+func querySdkVersion() (string, error) {
+
+	type arg struct{}
+
+	type out struct {
+		LbrynetVersion string `json:"lbrynet_version"`
+		Version        string `json:"version"`
+	}
+
+	o, err := rpcCall[arg, out]("version", arg{})
+	if err != nil {
+		return "", errors.Wrap(err, "error querying lbrynet version")
+	}
+
+	if o.LbrynetVersion != "" {
+		return o.LbrynetVersion, nil
+	}
+	return o.Version, nil
+}
+
+// Returns true if the claim is owned by the current wallet.  If the sdk
+// didn't report is_my_output the wallet's claim list is checked.
+func (c *sdkClaim) isMine() (bool, error) {
+
+	if c.IsMyOutput != nil {
+		return *c.IsMyOutput, nil
+	}
+
+	type arg struct {
+		ClaimId string `json:"claim_id"`
+	}
+
+	page, err := rpcCall[arg, sdkPage[*sdkOutput]]("claim_list", arg{
+		ClaimId: c.ClaimId,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(page.Items) > 0, nil
+}
@@ -0,0 +1,42 @@
+package glib
+
+import (
+	"testing"
+)
+
+func TestNewSdkCompat(t *testing.T) {
+	tests := []struct {
+		version  string
+		wantOk   bool
+		untested bool
+	}{
+		{version: "0.113.0", wantOk: true},
+		{version: "v0.113.0", wantOk: true},
+		{version: "0.113.1rc2", wantOk: true},
+		{version: "0.114.0", wantOk: true, untested: true},
+		{version: "0.112.0", wantOk: false},
+		{version: "0.72.0", wantOk: false},
+		{version: "0.48.0", wantOk: false},
+		{version: "1.0.0", wantOk: false},
+		{version: "latest", wantOk: false},
+		{version: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			v, err := parseSdkVersion(tt.version)
+			var sdk *sdkCompat
+			if err == nil {
+				sdk, err = newSdkCompat(v)
+			}
+			if (err == nil) != tt.wantOk {
+				t.Fatalf("newSdkCompat(%v) error = %v, wantOk %v", tt.version, err, tt.wantOk)
+			}
+			if err != nil {
+				return
+			}
+			if sdk.untested() != tt.untested {
+				t.Errorf("newSdkCompat(%v).untested() = %v", tt.version, sdk.untested())
+			}
+		})
+	}
+}
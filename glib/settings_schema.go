@@ -0,0 +1,159 @@
+package glib
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The latest version of the settings schema.  Documents in older versions
+// are migrated in memory when read, and rewritten by gitlbry upgrade
+//
+// 1  Times were stored under "ranges" and deleted claims under "Deleted"
+// 2  Times are only stored under "times", "ranges" only holds patch ranges
+// 3  Adds fields that change which patches are canonical: deleted patches
+//    are rolled back once applied, origin/predecessor/successor, frozen_at,
+//    freezes, recovery, approvals and heights in ref rules and
+//    maintainer_heights
+const settingsVersion = 3
+
+// The oldest version written in the current layout.  Settings are written
+// with the lowest version from here on that understands every field they
+// use, see requiredVersion
+const settingsLayoutVersion = 2
+
+// Converts a settings document from version n to n+1, indexed by n-1
+var settingsMigrations = []func(map[string]json.RawMessage) (map[string]json.RawMessage, error){
+	migrateSettingsV1,
+	migrateSettingsV2,
+}
+
+// The lowest version of the schema that understands every field the
+// settings use.  A client that ignored one of these fields would apply
+// different patches, so it must refuse the document instead
+func (s *glSettings) requiredVersion() int {
+	newer := false
+	for _, rule := range s.Refs {
+		newer = newer || rule.Approvals > 0 || len(rule.Heights) > 0
+	}
+	for _, author := range s.Authors {
+		newer = newer || len(author.MaintainerHeights) > 0
+	}
+	if len(s.Deleted) > 0 || s.Origin != "" || s.Predecessor != "" || s.Successor != "" ||
+		s.FrozenAt != 0 || len(s.Freezes) > 0 || len(s.Recovery) > 0 || newer {
+		return 3
+	}
+	return settingsLayoutVersion
+}
+
+// Encodes settings for publishing, labelled with the version they require.
+// Documents read in an older layout must be rewritten with gitlbry upgrade
+// first, since clients that read that layout don't check the version
+func encodeSettings(s *glSettings) ([]byte, error) {
+	if s.Gitlbry < settingsLayoutVersion {
+		return nil, errors.Errorf("the repo's settings are version %v, run gitlbry upgrade first", s.Gitlbry)
+	}
+	s.Gitlbry = s.requiredVersion()
+	return json.Marshal(s)
+}
+
+// Parses settings in any known version of the schema.  The result is in the
+// latest layout but keeps the version of the document in Gitlbry
+func decodeSettings(b []byte) (*glSettings, error) {
+
+	var doc map[string]json.RawMessage
+	err := json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading settings")
+	}
+
+	var version int
+	err = json.Unmarshal(doc["gitlbry"], &version)
+	if err != nil || version <= 0 {
+		return nil, errors.New("error reading settings, missing version.  The url may not reference a git repo")
+	}
+	if version > settingsVersion {
+		return nil, errors.Errorf("the repo's settings are version %v but this gitlbry only understands up to version %v, please update gitlbry", version, settingsVersion)
+	}
+
+	for v := version; v < settingsVersion; v += 1 {
+		doc, err = settingsMigrations[v-1](doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error migrating settings from version %v", v)
+		}
+	}
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings glSettings
+	err = json.Unmarshal(b, &settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading settings")
+	}
+
+	settings.Gitlbry = version
+	return &settings, nil
+}
+
+// Version 1 wrote deleted claims under "Deleted" due to a typo in the struct
+// tag, and older documents stored each author's time stamps under "ranges"
+// before patch ranges existed
+func migrateSettingsV1(doc map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+
+	for key, value := range doc {
+		if key != "deleted" && strings.EqualFold(key, "deleted") {
+			delete(doc, key)
+			doc["deleted"] = value
+		}
+	}
+
+	var authors []map[string]json.RawMessage
+	if raw, ok := doc["authors"]; ok && string(raw) != "null" {
+		err := json.Unmarshal(raw, &authors)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, author := range authors {
+
+		var times []int64
+		if json.Unmarshal(author["ranges"], &times) != nil {
+			continue
+		}
+
+		var existing []int64
+		if raw, ok := author["times"]; ok {
+			err := json.Unmarshal(raw, &existing)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := json.Marshal(append(times, existing...))
+		if err != nil {
+			return nil, err
+		}
+		author["times"] = b
+		delete(author, "ranges")
+	}
+
+	if authors != nil {
+		b, err := json.Marshal(authors)
+		if err != nil {
+			return nil, err
+		}
+		doc["authors"] = b
+	}
+
+	return doc, nil
+}
+
+// Version 3 only added fields
+func migrateSettingsV2(doc map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	return doc, nil
+}
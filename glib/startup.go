@@ -7,21 +7,34 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type RepoName struct {
 
-	// The name of the remote according to git
+	// The url of the remote according to git
 	url lbryUrl
 
+	// The perminant url of the repo's settings claim.  Local state is keyed
+	// by this so that every url that resolves to the same repo shares it
+	permanentUrl lbryUrl
+
 	// The Stram_Name part of the lbry url
 	name string
 
+	// The claim id of the repo's settings claim
+	claimId string
+
+	// Options given in the url's query
+	options remoteOptions
+
 	// Controlls where local files are located
 	hash string
 }
@@ -35,11 +48,11 @@ func (rh RepoName) settingsPath() string {
 }
 
 func (rh RepoName) inBundlePath(index int) string {
-	return fmt.Sprintf("%s/in/%d.bundle", rh.hash, index)
+	return fmt.Sprintf("%s/%d.bundle", rh.inPath(), index)
 }
 
 func (rh RepoName) outBundlePath(index int) string {
-	return fmt.Sprintf("%s/out/%d.bundle", rh.hash, index)
+	return fmt.Sprintf("%s/%d.bundle", rh.outPath(), index)
 }
 
 func (rh RepoName) inPath() string {
@@ -55,39 +68,99 @@ func (rh RepoName) gitRemoteClonePath() string {
 }
 
 func (rh RepoName) rootPath() string {
-	return fmt.Sprintf(".glbry/%s", rh.hash)
+	return repoRootPath(rh.hash)
 }
 
+func repoRootPath(hash string) string {
+	return fmt.Sprintf(".glbry/%s", hash)
+}
+
+// The local clone is a bare repo at the root path
 func (rh RepoName) headPath() string {
-	return fmt.Sprintf("%s/.git/HEAD", rh.rootPath())
+	return fmt.Sprintf("%s/HEAD", rh.rootPath())
 }
 
-func NewRepoName(lbryUrl string) (RepoName, error) {
+func urlHash(x string) string {
+	var h = sha1.Sum([]byte(x))
+	return hex.EncodeToString(h[:])
+}
 
-	// Hash
-	var h = sha1.Sum([]byte(lbryUrl))
-	hash := hex.EncodeToString(h[:]);
+// Creates a RepoName for the repo at lbryUrl whose settings claim resolved to
+// permanentUrl
+func NewRepoName(lbryUrl string, permanentUrl string) (RepoName, error) {
 
 	// Url
 	u, err := NewLbryUrl(lbryUrl)
 	if err != nil {
-		return zero[RepoName](), nil
+		return zero[RepoName](), errors.Wrapf(err, "invalid url for repo: %v", lbryUrl)
+	}
+
+	p, err := NewLbryUrl(permanentUrl)
+	if err != nil {
+		return zero[RepoName](), errors.Wrapf(err, "invalid perminant url for repo: %v", permanentUrl)
 	}
 
 	// name
-	name, ok := u.StreamName()
+	name, ok := p.StreamName()
 	if !ok {
 		return zero[RepoName](), errors.Errorf("invalid url for repo: %v\n  Url may refer to a channel instead of a stream", lbryUrl)
 	}
 
+	// Perminant urls always include the full claim id
+	if p.streamModifier.kind != '#' {
+		return zero[RepoName](), errors.Errorf("invalid perminant url for repo: %v, missing claim id", permanentUrl)
+	}
+
+	options, err := parseRemoteOptions(u)
+	if err != nil {
+		return zero[RepoName](), err
+	}
+
+	// A snapshot is a different view of the repo and needs its own local
+	// clone
+	key := p.WithoutQuery()
+	if options.snapshot >= 0 {
+		key.query = []lbryQueryParam{{name: "snapshot", value: fmt.Sprint(options.snapshot)}}
+	}
+
 	return RepoName{
-		url:  u,
-		hash: hash,
-		name: name,
+		url:          u,
+		permanentUrl: p,
+		hash:         urlHash(key.String()),
+		name:         name,
+		claimId:      p.streamModifier.value,
+		options:      options,
 	}, nil
 
 }
 
+// Local state used to be keyed by the hash of the url text given to git.  If
+// that directory exists, move it to where it is now expected
+func (rh RepoName) migrateLocalDirectory(lbryUrl string) error {
+
+	oldPath := repoRootPath(urlHash(lbryUrl))
+	if oldPath == rh.rootPath() {
+		return nil
+	}
+
+	oldExists, err := fileExists(oldPath)
+	if err != nil || !oldExists {
+		return err
+	}
+
+	exists, err := fileExists(rh.rootPath())
+	if err != nil {
+		return err
+	}
+	if exists {
+		OutPrintf("not migrating %v, %v already exists", oldPath, rh.rootPath())
+		return nil
+	}
+
+	OutPrintf("migrating %v to %v", oldPath, rh.rootPath())
+	return os.Rename(oldPath, rh.rootPath())
+}
+
 type Startup struct {
 
 	// Normalized, perminant path to repo in the form
@@ -98,6 +171,9 @@ type Startup struct {
 
 	sync Sync
 
+	// Permissions for the remote repo as downloaded at startup
+	settings *glSettings
+
 	// The value of the remote head.  This could be a symbolic ref e.g.
 	// "@refs/heads/master" or the sha1 hash of a commit e.g. "ccdddd6c5b19436e52146dfc11fd8632ca60b31b"
 	head string
@@ -117,6 +193,23 @@ type Sync struct {
 	// The number of bundle files that have been successfully applied
 	// to the local repository
 	Index int
+
+	// The patch at DownloadIndex if it is waiting for approvals, nil if
+	// there is none.  Later patches aren't downloaded until it is approved
+	// or deleted
+	Pending *PendingPatch `json:",omitempty"`
+
+	// Claim id of the repo's first settings claim, which recovery claims are
+	// named after
+	Origin string `json:",omitempty"`
+
+	// Claim ids of the recovery channels trusted to replace the settings.
+	// Pinned from the first settings that named any, see pinRecovery.  Kept
+	// here rather than in the cache, which is rebuilt when it's unreadable
+	Recovery []string `json:",omitempty"`
+
+	// Permanent url of the recovery claim the repo was recovered to, if any
+	Recovered string `json:",omitempty"`
 }
 
 func zero[T any]() T {
@@ -126,14 +219,44 @@ func zero[T any]() T {
 
 func startup(lbryurl string) (Startup, error) {
 
-	rh, err := NewRepoName(lbryurl)
+	// Links to web frontends listed in the config e.g.
+	// https://odysee.com/@chan:1/repo:2 are accepted as remote urls
+	lbryurl = fromGatewayUrl(lbryurl, loadConfig().gatewayHosts())
+
+	u, err := NewLbryUrl(lbryurl)
+	if err != nil {
+		return zero[Startup](), errors.Wrapf(err, "invalid url for repo: %v", lbryurl)
+	}
+
+	// Fail early if the sdk can't be used
+	OutPrintf("checking lbrynet version")
+	_, err = lbrySdk()
 	if err != nil {
 		return zero[Startup](), err
 	}
 
-	// Aquire filesystem lock
-	err = rh.lock()
+	// Resolve the settings claim.  This identifies the repo and is the only
+	// lookup needed to tell if the cached settings are still current
+	OutPrintf("resolving repo")
+	repo, err := lbryResolve(u.WithoutQuery().String())
+	if err != nil {
+		return zero[Startup](), err
+	}
+
+	rh, err := NewRepoName(lbryurl, repo.PermanentUrl)
+	if err != nil {
+		return zero[Startup](), err
+	}
+
+	// Aquire filesystem lock.  Taken before migrating so that concurrent
+	// fetches don't both move the old directory
 	OutPrintf("Aquireing lock")
+	err = rh.lock()
+	if err != nil {
+		return zero[Startup](), err
+	}
+
+	err = rh.migrateLocalDirectory(lbryurl)
 	if err != nil {
 		return zero[Startup](), err
 	}
@@ -152,23 +275,77 @@ func startup(lbryurl string) (Startup, error) {
 		return zero[Startup](), err
 	}
 
-	// Download settings from lbry
+	// Download settings from lbry if they changed
 	OutPrintf("loading settings")
-	settings, err := downloadSettings(string(rh.url), rh.settingsPath())
+	cache := rh.loadCache()
+	settings, err := rh.loadSettings(repo, cache)
 	if err != nil {
 		return zero[Startup](), err
 	}
 
-	// Update .gitlbry/<reposhash>/in from the lbry network
-	OutPrintf("getting changes from lbry network")
-	err = rh.downloadBundles(&sync, settings)
+	// Recovery claims and amendments published since the last fetch
+	key := searchKey(repo)
+	search := key == "" || key != cache.Searched
+
+	// Switch to settings published by a recovery channel if the owner was
+	// compromised.  Done before following moves, which the owner controls
+	settings, rh.claimId, err = recoverSettings(settings.origin(rh.claimId), rh.claimId, settings, &sync, search)
 	if err != nil {
 		return zero[Startup](), err
 	}
 
-	// Apply changes to a local .git repo that clones lbry
+	// Follow the repo if it was moved to a new settings claim.  Local state
+	// stays keyed by the url it was cloned from
+	settings, rh.claimId, err = followSuccessors(rh.claimId, settings)
+	if err != nil {
+		return zero[Startup](), err
+	}
+
+	// Merge in changes made by maintainers
+	OutPrintf("loading amendments")
+	found, ok := cache.foundAmendments()
+	if search || !ok {
+		err = loadAmendments(rh.claimId, settings, cache)
+		if err != nil {
+			return zero[Startup](), err
+		}
+	} else {
+		settings.applyAmendments(found)
+	}
+	cache.Searched = key
+
+	// The owner may have deleted or frozen a patch that was already applied
+	err = rh.fillPatchCache(settings, sync.Index, cache)
+	if err != nil {
+		return zero[Startup](), err
+	}
+	cache.refreshPatchHeights(settings, sync.Index)
+	rejected := cache.firstRejectedPatch(settings, sync.Index)
+	if rejected >= 0 {
+		OutPrintf("patch %v was deleted or published while frozen", rejected)
+		err = rh.rebuildFrom(rejected, &sync, cache)
+		if err != nil {
+			return zero[Startup](), err
+		}
+	}
+
+	// Finish applying bundles downloaded by an earlier run
 	OutPrintf("applying remote changes to a local clone")
-	err = rh.applyBundles(sync)
+	err = rh.applyBundles(&sync)
+	if err != nil {
+		return zero[Startup](), err
+	}
+
+	// Update .gitlbry/<reposhash>/in from the lbry network, applying each
+	// patch to the local clone as it is accepted
+	OutPrintf("getting changes from lbry network")
+	err = rh.downloadBundles(&sync, settings, cache)
+	if err != nil {
+		return zero[Startup](), err
+	}
+
+	// Remember what was found for next time
+	err = rh.saveCache(cache)
 	if err != nil {
 		return zero[Startup](), err
 	}
@@ -197,9 +374,12 @@ func startup(lbryurl string) (Startup, error) {
 	// Done, success
 	OutPrintf("startup success")
 	return Startup{
-		rh:   rh,
-		refs: refs,
-		head: head,
+		name:     rh.permanentUrl.String(),
+		rh:       rh,
+		sync:     sync,
+		settings: settings,
+		refs:     refs,
+		head:     head,
 	}, nil
 
 }
@@ -272,38 +452,38 @@ func (rh RepoName) loadHead() (string, error) {
 	return out, nil
 }
 
+// Loads sync.json.  Unlike the cache it can't be rebuilt from the lbry
+// network, since it holds the pinned recovery channels, so a missing or
+// unreadable file is an error
 func (rh RepoName) loadSync() (Sync, error) {
 	path := rh.syncPath()
-	b, _ := ioutil.ReadFile(path)
 	var i Sync
-	err := json.Unmarshal(b, &i)
-	return i, err
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return i, err
+	}
+	err = json.Unmarshal(b, &i)
+	return i, errors.Wrapf(err, "error reading %v", path)
 }
 
 func downloadSettings(lbryUrl string, fileName string) (*glSettings, error) {
 
-	// For Now, just re-download on every invocation
-	// Would be better to check the header and only re-download when necessary
 	err := lbryGet(lbryUrl, fileName)
 	if err != nil {
 		return nil, err
 	}
 
-	// Open File
-	fid, err := os.Open(fileName)
-	if err != nil {
-		return nil, err
-	}
-	defer fid.Close()
+	return readSettings(fileName)
+}
 
-	// Read and Parse
-	var settings glSettings
-	err = json.NewDecoder(fid).Decode(&settings)
+func readSettings(fileName string) (*glSettings, error) {
+
+	b, err := os.ReadFile(fileName)
 	if err != nil {
 		return nil, err
 	}
 
-	return &settings, nil
+	return decodeSettings(b)
 
 }
 
@@ -374,24 +554,10 @@ func (s *glSettings) isDeleted(claimId string) bool {
 	return false
 }
 
-func (s *glSettings) isAuthorized(channelId string, timestamp int64) bool {
+func (s *glSettings) isAuthorized(channelId string, timestamp int64, height int64, index int64) bool {
 	for _, author := range s.Authors {
-		if author.ClaimId == channelId {
-			i := 0
-			for ; i+1 < len(author.Times); i += 2 {
-				start := author.Times[i]
-				end := author.Times[i+1]
-				if start <= timestamp && timestamp < end {
-					return true
-				}
-			}
-
-			if i < len(author.Times) {
-				start := author.Times[i]
-				if start <= timestamp {
-					return true
-				}
-			}
+		if author.ClaimId == channelId && author.isAuthorized(timestamp, height, index) {
+			return true
 		}
 	}
 	return false
@@ -411,14 +577,72 @@ func getDescription(raw json.RawMessage) string {
 	return a.Description
 }
 
-// Finds the perminant url of bundle with the given name and description
-func findBundle(name string, description string, settings *glSettings) (string, error) {
+// A patch claim found on the lbry network
+type bundleClaim struct {
+	PermanentUrl  string
+	ClaimId       string
+	ChannelId     string
+	Txid          string
+	Nout          int
+	Height        int
+	Confirmations int
+}
+
+func (b *bundleClaim) cached() *cachedClaim {
+	return &cachedClaim{
+		ClaimId:      b.ClaimId,
+		Txid:         b.Txid,
+		Nout:         b.Nout,
+		Height:       b.Height,
+		PermanentUrl: b.PermanentUrl,
+	}
+}
+
+// Finds the canonical bundle with the given name and description.  Candidates
+// are searched oldest first so that the oldest authorized patch wins.  Ref
+// rules aren't checked since that needs the bundle's contents.
+func findBundle(name string, index int, description string, settings *glSettings) (*bundleClaim, error) {
+	candidates, err := findBundles(name, index, description, settings)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, BundleNotFoundErr
+	}
+	return candidates[0], nil
+}
+
+// Finds every authorized bundle with the given name and description, oldest
+// first
+func findBundles(name string, index int, description string, settings *glSettings) ([]*bundleClaim, error) {
+	return searchBundles(name, description, []string{"^height"}, settings, func(channelId string, timestamp int64, height int64) bool {
+		return settings.isAuthorized(channelId, timestamp, height, int64(index))
+	})
+}
+
+// Finds unconfirmed patches with the given name and description published
+// by channels that will be authorized to publish them once they confirm.
+// Only returns anything if the hub indexes the mem-pool
+func findUnconfirmedBundles(name string, index int, description string, settings *glSettings) ([]*bundleClaim, error) {
+
+	// The patch will confirm after every confirmed grant and revoke
+	now := time.Now().Unix()
+	return searchBundles(name, description, nil, settings, func(channelId string, timestamp int64, height int64) bool {
+		return height <= 0 && settings.isAuthorized(channelId, now, math.MaxInt64, int64(index))
+	})
+}
+
+// Searches for patches with the given name and description signed by one of
+// the repo's authors, sorted by orderBy.  Deleted patches and patches
+// confirmed while the repo was frozen are left out, as are those keep
+// returns false for given the signing channel, timestamp and height
+func searchBundles(name string, description string, orderBy []string, settings *glSettings, keep func(channelId string, timestamp int64, height int64) bool) ([]*bundleClaim, error) {
 
 	type arg struct {
 		Name       string   `json:"name"`
 		ChannelIds []string `json:"channel_ids"`
 		PageSize   int      `json:"page_size"`
-		// OrderBy    string   `json:"height"`
+		OrderBy    []string `json:"order_by,omitempty"`
 	}
 
 	type signChan struct {
@@ -429,7 +653,11 @@ func findBundle(name string, description string, settings *glSettings) (string,
 		withError
 		PermanentUrl   string    `json:"permanent_url"`
 		ClaimId        string    `json:"claim_id"`
+		Txid           string    `json:"txid"`
+		Nout           int       `json:"nout"`
+		Height         int       `json:"height"`
 		Timestamp      int64     `json:"timestamp"`
+		Confirmations  int       `json:"confirmations"`
 		SigningChannel *signChan `json:"signing_channel"`
 		Value          json.RawMessage
 	}
@@ -438,94 +666,190 @@ func findBundle(name string, description string, settings *glSettings) (string,
 		Name:       name,
 		ChannelIds: Map(settings.Authors, func(a *glAuthor) string { return a.ClaimId }),
 		PageSize:   5000,
-		//OrderBy:    "publish_time",
+		OrderBy:    orderBy,
 	})
-
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
+	var result []*bundleClaim
 	for _, item := range page.Items {
 		if item.Error == nil &&
 			!settings.isDeleted(item.ClaimId) &&
+			!settings.isFrozenAt(int64(item.Height)) &&
 			item.SigningChannel != nil &&
-			settings.isAuthorized(item.SigningChannel.ClaimId, item.Timestamp) &&
+			keep(item.SigningChannel.ClaimId, item.Timestamp, int64(item.Height)) &&
 			getDescription(item.Value) == description {
 
-			// Found it
-			return item.PermanentUrl, nil
+			result = append(result, &bundleClaim{
+				PermanentUrl:  item.PermanentUrl,
+				ClaimId:       item.ClaimId,
+				ChannelId:     item.SigningChannel.ClaimId,
+				Txid:          item.Txid,
+				Nout:          item.Nout,
+				Height:        item.Height,
+				Confirmations: item.Confirmations,
+			})
 		}
 	}
 
-	return "", BundleNotFoundErr
-
+	return result, nil
 }
 
-func (rh RepoName) downloadBundles(sync *Sync, settings *glSettings) error {
+func (rh RepoName) downloadBundles(sync *Sync, settings *glSettings, cache *claimCache) error {
 
 	for {
+		sync.Pending = nil
+
+		// Pinned to a snapshot
+		snapshot := rh.options.snapshot
+		if snapshot >= 0 && sync.DownloadIndex > snapshot {
+			OutPrintf("Reached snapshot %v.  Sync complete", snapshot)
+			break
+		}
 
 		OutPrintf("Searching for bundle %v with priorhash='%v'", sync.DownloadIndex, sync.DownloadPriorHash)
 
 		// Repo Name
-		name := fmt.Sprintf("%v-%v", rh.name, sync.DownloadIndex)
+		name := settings.patchName(rh, sync.DownloadIndex)
 		description := sync.DownloadPriorHash
 
-		// Find next bundle
-		bundleUrl, err := findBundle(name, description, settings)
-
-		// Check for Successfull completion
-		if err == BundleNotFoundErr {
-			OutPrintf("Bundle not found.  Sync complete")
-			break
-		}
+		// Find candidates for the next bundle
+		candidates, err := findBundles(name, sync.DownloadIndex, description, settings)
 		if err != nil {
 			OutPrintf("Error searching for bundle %v", err.Error())
 			return err
 		}
 
-		// Download bundle
+		// The oldest candidate that follows the ref rules and gets its
+		// approvals in time is canonical
 		path := rh.inBundlePath(sync.DownloadIndex)
-		err = lbryGet(bundleUrl, path)
-		if err != nil {
-			return err
+		var bundle *bundleClaim
+		var heads []NamedRef
+		var prior string
+		for _, candidate := range candidates {
+			heads, err = rh.downloadBundle(candidate, path, settings)
+			if err == nil {
+
+				// Calc Sha Hash For Next Bundle
+				prior, err = fileSha1(path)
+				if err != nil {
+					return err
+				}
+
+				// Held until enough maintainers approve it, neither applied
+				// nor rejected
+				sync.Pending, err = rh.checkApprovals(candidate, prior, heads, settings, cache)
+			}
+			if err == nil {
+				bundle = candidate
+				break
+			}
+			if _, ok := err.(*rejectedBundleErr); !ok {
+				return err
+			}
+			OutPrintf("ignoring patch %v: %v", candidate.PermanentUrl, err)
 		}
 
-		// Calc Sha Hash For Next Bundle
-		fid, err := os.Open(path)
-		if err != nil {
-			return err
+		// Check for Successfull completion
+		if bundle == nil {
+			OutPrintf("Bundle not found.  Sync complete")
+			break
+		}
+
+		if sync.Pending != nil {
+			OutPrintf("patch %v has %v of %v approvals, approvals count until block %v.  Sync paused", sync.DownloadIndex, sync.Pending.Approvals, sync.Pending.Required, sync.Pending.Deadline)
+			break
 		}
-		hash := sha1.New()
-		_, err = io.Copy(hash, fid)
+
+		// The objects are already in the local clone
+		err = rh.updateRefs(heads)
 		if err != nil {
 			return err
 		}
-		prior := hex.EncodeToString(hash.Sum(nil))
 
 		// Prep for next download
+		cache.setPatch(sync.DownloadIndex, bundle.cached())
 		sync.DownloadIndex += 1
 		sync.DownloadPriorHash = prior
+		sync.Index = sync.DownloadIndex
 	}
 
 	return nil
 
 }
 
-func (rh RepoName) applyBundles(sync Sync) error {
+// A patch that can't be applied or breaks the ref rules and so isn't
+// canonical
+type rejectedBundleErr struct {
+	why error
+}
+
+func (e *rejectedBundleErr) Error() string {
+	return e.why.Error()
+}
+
+// Downloads the bundle to path and stores its objects in the local clone.
+// Returns the refs it sets, or a rejectedBundleErr if the bundle can't be
+// unbundled or breaks the ref rules
+func (rh RepoName) downloadBundle(bundle *bundleClaim, path string, settings *glSettings) ([]NamedRef, error) {
+
+	err := lbryGet(bundle.PermanentUrl, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// git runs in the clone so the path can't be relative
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	heads, err := rh.unbundle(abs)
+	if err != nil {
+		return nil, &rejectedBundleErr{why: err}
+	}
+
+	err = rh.checkRefRules(bundle.ChannelId, int64(bundle.Height), heads, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return heads, nil
+}
+
+func fileSha1(path string) (string, error) {
+	fid, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fid.Close()
+
+	hash := sha1.New()
+	_, err = io.Copy(hash, fid)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Applies bundles that were downloaded but not yet applied to the local clone
+func (rh RepoName) applyBundles(sync *Sync) error {
 
 	for n := sync.Index; n < sync.DownloadIndex; n += 1 {
 
-		cmd := exec.Command(
-			"git",
-			"bundle",
-			"unbundle",
-			rh.inBundlePath(n),
-		)
+		// git runs in the clone so the path can't be relative
+		path, err := filepath.Abs(rh.inBundlePath(n))
+		if err != nil {
+			return err
+		}
+
+		heads, err := rh.unbundle(path)
+		if err != nil {
+			return err
+		}
 
-		cmd.Dir = rh.rootPath()
-		out, err := cmd.CombinedOutput()
-		OutPrintf("git bundle %v", string(out))
+		err = rh.updateRefs(heads)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,81 @@
+package glib
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Most successors followed before giving up, guards against cycles
+const maxSuccessors = 16
+
+// Returns the claim id of the repo's first settings claim given the claim id
+// of the current one
+func (s *glSettings) origin(claimId string) string {
+	if s.Origin != "" {
+		return s.Origin
+	}
+	return claimId
+}
+
+// Returns a copy of the settings to publish as the successor of the settings
+// claim with the given claim id
+func (s *glSettings) successorOf(claimId string) (*glSettings, error) {
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var next glSettings
+	err = json.Unmarshal(b, &next)
+	if err != nil {
+		return nil, err
+	}
+
+	next.Origin = s.origin(claimId)
+	next.Predecessor = claimId
+	next.Successor = ""
+	return &next, nil
+}
+
+// If the repo was moved with gitlbry transfer, follows the successor
+// pointers to the current settings claim.  Returns its settings and claim
+// id, or the given settings and claim id if the repo wasn't moved.  A
+// successor is only followed if it names the claim it replaces as its
+// predecessor.
+func followSuccessors(claimId string, settings *glSettings) (*glSettings, string, error) {
+
+	for i := 0; settings.Successor != ""; i += 1 {
+
+		if i == maxSuccessors {
+			return nil, "", errors.Errorf("gave up following the repo after %v moves", maxSuccessors)
+		}
+
+		OutPrintf("repo moved to %v", settings.Successor)
+		next, err := lbryResolve(settings.Successor)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "error resolving %v, where the repo moved to", settings.Successor)
+		}
+
+		path, err := newTempPath()
+		if err != nil {
+			return nil, "", err
+		}
+		nextSettings, err := downloadSettings(next.PermanentUrl, path)
+		os.Remove(path)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if nextSettings.Predecessor != claimId {
+			return nil, "", errors.Errorf("%v does not replace %v, not following the move", next.PermanentUrl, claimId)
+		}
+
+		nextSettings.resolvePendingHeights(next.Height)
+		settings = nextSettings
+		claimId = next.ClaimId
+	}
+
+	return settings, claimId, nil
+}